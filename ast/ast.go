@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"math/big"
+	"path/filepath"
 	"strings"
 
 	"github.com/lqqyt2423/go-monkey/token"
@@ -9,6 +11,9 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos is where the node starts in the source, or for operators and
+	// calls, where the operator is.
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -33,6 +38,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out strings.Builder
 	for _, s := range p.Statements {
@@ -44,22 +56,157 @@ func (p *Program) String() string {
 type LetStatement struct {
 	Token token.Token
 	Name  *Identifier
-	Value Expression
+	// Pattern destructures the value when it is set, in place of Name.
+	Pattern Pattern
+	Value   Expression
+	// Exported is set for the top-level bindings a module exports.
+	Exported bool
+}
+
+// Names returns the identifiers the statement binds.
+func (ls *LetStatement) Names() []*Identifier {
+	if ls.Pattern != nil {
+		return PatternNames(ls.Pattern)
+	}
+	return []*Identifier{ls.Name}
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 
 func (ls *LetStatement) String() string {
 	var out strings.Builder
+	if ls.Exported {
+		out.WriteString("export ")
+	}
 	out.WriteString("let ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 	out.WriteString(ls.Value.String())
 	out.WriteString(";")
 	return out.String()
 }
 
+// Pattern is the target of a destructuring binding: an *Identifier, a
+// *WildcardPattern, an *ArrayPattern or a *HashPattern. The patterns of a
+// match expression may also be *LiteralPattern.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// ArrayPattern binds the elements of an array, as in let [a, b, ...rest] = xs.
+type ArrayPattern struct {
+	Token    token.Token // the [ token
+	Elements []Pattern
+	// Rest collects the elements after Elements, if it is set.
+	Rest *Identifier
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+
+func (ap *ArrayPattern) String() string {
+	var elements []string
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern binds the values of a hash by key, as in
+// let {name, "age": years} = person. The keys are identifiers or string
+// literals.
+type HashPattern struct {
+	Token   token.Token // the { token
+	Keys    []Expression
+	Targets []Pattern
+}
+
+// KeyNames returns the keys of the pattern as strings.
+func (hp *HashPattern) KeyNames() []string {
+	names := make([]string, len(hp.Keys))
+	for i, key := range hp.Keys {
+		switch key := key.(type) {
+		case *Identifier:
+			names[i] = key.Value
+		case *StringLiteral:
+			names[i] = key.Value
+		}
+	}
+	return names
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+
+func (hp *HashPattern) String() string {
+	var entries []string
+	for i, key := range hp.Keys {
+		if ident, ok := hp.Targets[i].(*Identifier); ok && key == Expression(ident) {
+			entries = append(entries, key.String())
+			continue
+		}
+		entries = append(entries, key.String()+": "+hp.Targets[i].String())
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// WildcardPattern is _, which matches anything and binds nothing.
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) Pos() token.Position  { return wp.Token.Pos }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// LiteralPattern matches the values equal to a literal: a number, possibly
+// negated, a string or a boolean.
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) Pos() token.Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// PatternNames returns the identifiers p binds, in order.
+func PatternNames(p Pattern) []*Identifier {
+	switch p := p.(type) {
+	case *Identifier:
+		return []*Identifier{p}
+	case *ArrayPattern:
+		var names []*Identifier
+		for _, el := range p.Elements {
+			names = append(names, PatternNames(el)...)
+		}
+		if p.Rest != nil {
+			names = append(names, p.Rest)
+		}
+		return names
+	case *HashPattern:
+		var names []*Identifier
+		for _, target := range p.Targets {
+			names = append(names, PatternNames(target)...)
+		}
+		return names
+	}
+	return nil
+}
+
 type ReturnStatement struct {
 	Token       token.Token
 	ReturnValue Expression
@@ -67,6 +214,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 
 func (rs *ReturnStatement) String() string {
 	var out strings.Builder
@@ -83,6 +231,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 
 func (es *ExpressionStatement) String() string {
 	return es.Expression.String()
@@ -95,6 +244,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 
 func (bs *BlockStatement) String() string {
 	var out strings.Builder
@@ -104,13 +254,108 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+
+func (ws *WhileStatement) String() string {
+	var out strings.Builder
+	out.WriteString("while ")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+	return out.String()
+}
+
+// ForStatement is a for-in loop. Key is nil for the single variable form,
+// which binds each element of Iterable to Value.
+type ForStatement struct {
+	Token    token.Token
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+
+func (fs *ForStatement) String() string {
+	var out strings.Builder
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) String() string {
+	return "throw " + ts.Value.String() + ";"
+}
+
+// ImportStatement binds the module it imports to a name taken from the
+// file name, as in import "lib/strings.mk".
+type ImportStatement struct {
+	Token  token.Token
+	Name   *Identifier
+	Import *ImportExpression
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Pos() token.Position  { return is.Token.Pos }
+func (is *ImportStatement) String() string {
+	return "import \"" + is.Import.Path + "\";"
+}
+
 type Identifier struct {
 	Token token.Token
 	Value string
 }
 
 func (i *Identifier) expressionNode()      {}
+func (i *Identifier) patternNode()         {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 
 func (i *Identifier) String() string {
 	return i.Value
@@ -119,15 +364,31 @@ func (i *Identifier) String() string {
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	// Big holds the value of a literal too large for an int64.
+	Big *big.Int
 }
 
 func (i *IntegerLiteral) expressionNode()      {}
 func (i *IntegerLiteral) TokenLiteral() string { return i.Token.Literal }
+func (i *IntegerLiteral) Pos() token.Position  { return i.Token.Pos }
 
 func (i *IntegerLiteral) String() string {
 	return i.Token.Literal
 }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode()      {}
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+func (f *FloatLiteral) Pos() token.Position  { return f.Token.Pos }
+
+func (f *FloatLiteral) String() string {
+	return f.Token.Literal
+}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -135,6 +396,7 @@ type StringLiteral struct {
 
 func (s *StringLiteral) expressionNode()      {}
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
+func (s *StringLiteral) Pos() token.Position  { return s.Token.Pos }
 func (s *StringLiteral) String() string {
 	return `"` + s.Value + `"`
 }
@@ -146,6 +408,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 
 func (b *Boolean) String() string {
 	return b.Token.Literal
@@ -159,6 +422,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 
 func (pe *PrefixExpression) String() string {
 	var out strings.Builder
@@ -178,6 +442,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 
 func (ie *InfixExpression) String() string {
 	var out strings.Builder
@@ -189,6 +454,28 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression assigns Value to an existing binding or, when Target is
+// an IndexExpression, to an element of an array or hash.
+type AssignExpression struct {
+	Token  token.Token
+	Target Expression
+	Value  Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Token.Pos }
+
+func (ae *AssignExpression) String() string {
+	var out strings.Builder
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 type IfExpression struct {
 	Token       token.Token
 	Condition   Expression
@@ -198,6 +485,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 
 func (ie *IfExpression) String() string {
 	var out strings.Builder
@@ -212,21 +500,131 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// MatchExpression evaluates the body of the first arm whose pattern matches
+// Subject and whose guard, if any, holds.
+type MatchExpression struct {
+	Token   token.Token // the match token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+type MatchArm struct {
+	Pattern Pattern
+	// Guard is the condition after if, nil when there is none.
+	Guard Expression
+	Body  *BlockStatement
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+
+func (me *MatchExpression) String() string {
+	var arms []string
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+	return "match " + me.Subject.String() + " { " + strings.Join(arms, ", ") + " }"
+}
+
+func (ma *MatchArm) String() string {
+	var out strings.Builder
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+	return out.String()
+}
+
+// TryExpression has a Catch, a Finally or both. CatchParam is nil when the
+// catch clause does not bind the error.
+type TryExpression struct {
+	Token      token.Token
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+
+func (te *TryExpression) String() string {
+	var out strings.Builder
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.CatchParam != nil {
+			out.WriteString("(" + te.CatchParam.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+	return out.String()
+}
+
+type ImportExpression struct {
+	Token token.Token
+	Path  string
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *ImportExpression) String() string {
+	return "import(\"" + ie.Path + "\")"
+}
+
+// File is the file the import refers to: Path, relative to the directory
+// of the file the import is in.
+func (ie *ImportExpression) File() string {
+	if filepath.IsAbs(ie.Path) {
+		return filepath.Clean(ie.Path)
+	}
+	return filepath.Join(filepath.Dir(ie.Token.Pos.Filename), ie.Path)
+}
+
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStatement
+	// Defaults holds the default values of the parameters, nil for the
+	// ones that have none.
+	Defaults []Expression
+	// Patterns destructures the parameters, nil for the plain ones. The
+	// parameter of a pattern is named after it.
+	Patterns []Pattern
+	Body     *BlockStatement
+	// Rest collects the arguments after Parameters, if it is set.
+	Rest *Identifier
+	// Name is the name the function is bound to by let, or empty.
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 
 func (fl *FunctionLiteral) String() string {
 	var out strings.Builder
 
 	var params []string
-	for _, param := range fl.Parameters {
-		params = append(params, param.String())
+	for i, param := range fl.Parameters {
+		if i < len(fl.Defaults) && fl.Defaults[i] != nil {
+			params = append(params, param.String()+" = "+fl.Defaults[i].String())
+		} else {
+			params = append(params, param.String())
+		}
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
 	}
 
 	out.WriteString(fl.TokenLiteral())
@@ -238,14 +636,56 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// MacroLiteral is a macro, which is expanded before the program runs. Its
+// body works on the quoted arguments of the call and returns quoted code.
+type MacroLiteral struct {
+	Token      token.Token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
+
+func (ml *MacroLiteral) String() string {
+	var out strings.Builder
+
+	var params []string
+	for _, param := range ml.Parameters {
+		params = append(params, param.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(ml.Body.String())
+
+	return out.String()
+}
+
 type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// Keywords are the arguments passed by name, which follow Arguments.
+	Keywords []*KeywordArgument
+}
+
+// KeywordArgument is an argument passed by name, as in open(path, mode: "w").
+type KeywordArgument struct {
+	Name  *Identifier
+	Value Expression
+}
+
+func (ka *KeywordArgument) String() string {
+	return ka.Name.String() + ": " + ka.Value.String()
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 
 func (ce *CallExpression) String() string {
 	var out strings.Builder
@@ -254,6 +694,9 @@ func (ce *CallExpression) String() string {
 	for _, arg := range ce.Arguments {
 		args = append(args, arg.String())
 	}
+	for _, kw := range ce.Keywords {
+		args = append(args, kw.String())
+	}
 
 	out.WriteString(ce.Function.String())
 	out.WriteString("(")
@@ -263,6 +706,32 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// InterpolatedString is a string literal with ${} expressions in it. Parts
+// alternates between the text, as *StringLiteral, and the expressions,
+// leaving out empty text.
+type InterpolatedString struct {
+	Token token.Token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+
+func (is *InterpolatedString) String() string {
+	var out strings.Builder
+	out.WriteString(`"`)
+	for _, part := range is.Parts {
+		if str, ok := part.(*StringLiteral); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString("${" + part.String() + "}")
+		}
+	}
+	out.WriteString(`"`)
+	return out.String()
+}
+
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
@@ -270,6 +739,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 
 func (al *ArrayLiteral) String() string {
 	var out strings.Builder
@@ -291,6 +761,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 
 func (ie *IndexExpression) String() string {
 	var out strings.Builder
@@ -311,6 +782,7 @@ type HashLiteral struct {
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 
 func (hl *HashLiteral) String() string {
 	var out strings.Builder
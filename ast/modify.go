@@ -0,0 +1,162 @@
+package ast
+
+// ModifierFunc is called on every node Modify visits, and returns the node
+// to put in its place.
+type ModifierFunc func(Node) Node
+
+// Modify rebuilds the tree rooted at node depth first, replacing every node
+// with what modifier returns for it once its children have been modified.
+// The tree passed in is left as it is, so a quoted tree can be expanded
+// more than once.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case *Program:
+		c := *n
+		c.Statements = modifyStatements(n.Statements, modifier)
+		node = &c
+	case *ExpressionStatement:
+		c := *n
+		c.Expression, _ = Modify(n.Expression, modifier).(Expression)
+		node = &c
+	case *BlockStatement:
+		c := *n
+		c.Statements = modifyStatements(n.Statements, modifier)
+		node = &c
+	case *LetStatement:
+		c := *n
+		c.Value, _ = Modify(n.Value, modifier).(Expression)
+		node = &c
+	case *ReturnStatement:
+		c := *n
+		c.ReturnValue, _ = Modify(n.ReturnValue, modifier).(Expression)
+		node = &c
+	case *ThrowStatement:
+		c := *n
+		c.Value, _ = Modify(n.Value, modifier).(Expression)
+		node = &c
+	case *WhileStatement:
+		c := *n
+		c.Condition, _ = Modify(n.Condition, modifier).(Expression)
+		c.Body, _ = Modify(n.Body, modifier).(*BlockStatement)
+		node = &c
+	case *ForStatement:
+		c := *n
+		c.Iterable, _ = Modify(n.Iterable, modifier).(Expression)
+		c.Body, _ = Modify(n.Body, modifier).(*BlockStatement)
+		node = &c
+	case *PrefixExpression:
+		c := *n
+		c.Right, _ = Modify(n.Right, modifier).(Expression)
+		node = &c
+	case *InfixExpression:
+		c := *n
+		c.Left, _ = Modify(n.Left, modifier).(Expression)
+		c.Right, _ = Modify(n.Right, modifier).(Expression)
+		node = &c
+	case *AssignExpression:
+		c := *n
+		c.Target, _ = Modify(n.Target, modifier).(Expression)
+		c.Value, _ = Modify(n.Value, modifier).(Expression)
+		node = &c
+	case *IndexExpression:
+		c := *n
+		c.Left, _ = Modify(n.Left, modifier).(Expression)
+		c.Index, _ = Modify(n.Index, modifier).(Expression)
+		node = &c
+	case *IfExpression:
+		c := *n
+		c.Condition, _ = Modify(n.Condition, modifier).(Expression)
+		c.Consequence, _ = Modify(n.Consequence, modifier).(*BlockStatement)
+		if n.Alternative != nil {
+			c.Alternative, _ = Modify(n.Alternative, modifier).(*BlockStatement)
+		}
+		node = &c
+	case *MatchExpression:
+		c := *n
+		c.Subject, _ = Modify(n.Subject, modifier).(Expression)
+		c.Arms = make([]*MatchArm, len(n.Arms))
+		for i, arm := range n.Arms {
+			a := *arm
+			if arm.Guard != nil {
+				a.Guard, _ = Modify(arm.Guard, modifier).(Expression)
+			}
+			a.Body, _ = Modify(arm.Body, modifier).(*BlockStatement)
+			c.Arms[i] = &a
+		}
+		node = &c
+	case *TryExpression:
+		c := *n
+		c.Block, _ = Modify(n.Block, modifier).(*BlockStatement)
+		if n.Catch != nil {
+			c.Catch, _ = Modify(n.Catch, modifier).(*BlockStatement)
+		}
+		if n.Finally != nil {
+			c.Finally, _ = Modify(n.Finally, modifier).(*BlockStatement)
+		}
+		node = &c
+	case *FunctionLiteral:
+		c := *n
+		c.Parameters = make([]*Identifier, len(n.Parameters))
+		for i, param := range n.Parameters {
+			c.Parameters[i], _ = Modify(param, modifier).(*Identifier)
+		}
+		if n.Defaults != nil {
+			c.Defaults = make([]Expression, len(n.Defaults))
+			for i, def := range n.Defaults {
+				if def != nil {
+					c.Defaults[i], _ = Modify(def, modifier).(Expression)
+				}
+			}
+		}
+		if n.Rest != nil {
+			c.Rest, _ = Modify(n.Rest, modifier).(*Identifier)
+		}
+		c.Body, _ = Modify(n.Body, modifier).(*BlockStatement)
+		node = &c
+	case *CallExpression:
+		c := *n
+		c.Function, _ = Modify(n.Function, modifier).(Expression)
+		c.Arguments = modifyExpressions(n.Arguments, modifier)
+		if n.Keywords != nil {
+			c.Keywords = make([]*KeywordArgument, len(n.Keywords))
+			for i, kw := range n.Keywords {
+				value, _ := Modify(kw.Value, modifier).(Expression)
+				c.Keywords[i] = &KeywordArgument{Name: kw.Name, Value: value}
+			}
+		}
+		node = &c
+	case *InterpolatedString:
+		c := *n
+		c.Parts = modifyExpressions(n.Parts, modifier)
+		node = &c
+	case *ArrayLiteral:
+		c := *n
+		c.Elements = modifyExpressions(n.Elements, modifier)
+		node = &c
+	case *HashLiteral:
+		c := *n
+		c.Pairs = make(map[Expression]Expression, len(n.Pairs))
+		for key, value := range n.Pairs {
+			newKey, _ := Modify(key, modifier).(Expression)
+			c.Pairs[newKey], _ = Modify(value, modifier).(Expression)
+		}
+		node = &c
+	}
+	return modifier(node)
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) []Statement {
+	modified := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		modified[i], _ = Modify(stmt, modifier).(Statement)
+	}
+	return modified
+}
+
+func modifyExpressions(exps []Expression, modifier ModifierFunc) []Expression {
+	modified := make([]Expression, len(exps))
+	for i, exp := range exps {
+		modified[i], _ = Modify(exp, modifier).(Expression)
+	}
+	return modified
+}
@@ -0,0 +1,105 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok || integer.Value != 1 {
+			return node
+		}
+		return &IntegerLiteral{Value: 2}
+	}
+
+	tests := []struct {
+		input Node
+		want  Node
+	}{
+		{one(), two()},
+		{
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+		},
+		{&InfixExpression{Left: one(), Operator: "+", Right: two()}, &InfixExpression{Left: two(), Operator: "+", Right: two()}},
+		{&InfixExpression{Left: two(), Operator: "+", Right: one()}, &InfixExpression{Left: two(), Operator: "+", Right: two()}},
+		{&PrefixExpression{Operator: "-", Right: one()}, &PrefixExpression{Operator: "-", Right: two()}},
+		{&IndexExpression{Left: one(), Index: one()}, &IndexExpression{Left: two(), Index: two()}},
+		{
+			&IfExpression{
+				Condition:   one(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&IfExpression{
+				Condition:   two(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{
+			&TryExpression{
+				Block: &BlockStatement{Statements: []Statement{&ThrowStatement{Value: one()}}},
+				Catch: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&TryExpression{
+				Block: &BlockStatement{Statements: []Statement{&ThrowStatement{Value: two()}}},
+				Catch: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{
+			&WhileStatement{Condition: one(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&WhileStatement{Condition: two(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{&ReturnStatement{ReturnValue: one()}, &ReturnStatement{ReturnValue: two()}},
+		{&LetStatement{Name: &Identifier{Value: "x"}, Value: one()}, &LetStatement{Name: &Identifier{Value: "x"}, Value: two()}},
+		{
+			&FunctionLiteral{Parameters: []*Identifier{}, Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&FunctionLiteral{Parameters: []*Identifier{}, Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&FunctionLiteral{Parameters: []*Identifier{{Value: "a"}, {Value: "b"}}, Defaults: []Expression{nil, one()}, Body: &BlockStatement{Statements: []Statement{}}},
+			&FunctionLiteral{Parameters: []*Identifier{{Value: "a"}, {Value: "b"}}, Defaults: []Expression{nil, two()}, Body: &BlockStatement{Statements: []Statement{}}},
+		},
+		{&CallExpression{Function: one(), Arguments: []Expression{one()}}, &CallExpression{Function: two(), Arguments: []Expression{two()}}},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{}, Keywords: []*KeywordArgument{{Name: &Identifier{Value: "k"}, Value: one()}}},
+			&CallExpression{Function: two(), Arguments: []Expression{}, Keywords: []*KeywordArgument{{Name: &Identifier{Value: "k"}, Value: two()}}},
+		},
+		{&AssignExpression{Target: one(), Value: one()}, &AssignExpression{Target: two(), Value: two()}},
+		{
+			&MatchExpression{Subject: one(), Arms: []*MatchArm{{Pattern: &WildcardPattern{}, Guard: one(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}}}},
+			&MatchExpression{Subject: two(), Arms: []*MatchArm{{Pattern: &WildcardPattern{}, Guard: two(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}}}},
+		},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, &ArrayLiteral{Elements: []Expression{two(), two()}}},
+		{&InterpolatedString{Parts: []Expression{one()}}, &InterpolatedString{Parts: []Expression{two()}}},
+	}
+	for _, tt := range tests {
+		before := tt.input.String()
+		modified := Modify(tt.input, turnOneIntoTwo)
+		if !reflect.DeepEqual(modified, tt.want) {
+			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.want)
+		}
+		if tt.input.String() != before {
+			t.Errorf("input modified in place: %s, was %s", tt.input.String(), before)
+		}
+	}
+
+	hash := &HashLiteral{Pairs: map[Expression]Expression{one(): one(), one(): one()}}
+	modified := Modify(hash, turnOneIntoTwo).(*HashLiteral)
+	for key, val := range modified.Pairs {
+		key, _ := key.(*IntegerLiteral)
+		if key.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, key.Value)
+		}
+		val, _ := val.(*IntegerLiteral)
+		if val.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, val.Value)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package code
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/lqqyt2423/go-monkey/token"
 )
 
 type Instructions []byte
@@ -34,10 +37,32 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
 
+// SourcePos records that the instructions from Offset on were compiled
+// from the source at Pos.
+type SourcePos struct {
+	Offset int
+	Pos    token.Position
+}
+
+// PosTable maps instructions back to the source, one entry per change of
+// position, sorted by offset.
+type PosTable []SourcePos
+
+// Lookup returns the source position of the instruction at offset.
+func (t PosTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return t[i-1].Pos
+}
+
 type Opcode byte
 
 const (
@@ -67,6 +92,42 @@ const (
 	OpGetLocal
 	OpSetLocal
 	OpGetBuiltin
+	OpClosure
+	OpGetFree
+	OpHash
+	OpIterator
+	OpIterNext
+	OpSetFree
+	OpSetIndex
+	OpJumpNotTruthyOrPop
+	OpJumpTruthyOrPop
+	OpGreaterThanOrEqual
+	OpMod
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpBitNot
+	OpConcat
+	OpLessThan
+	OpLessThanOrEqual
+	OpTry
+	OpEndTry
+	OpThrow
+	OpCatch
+	OpImport
+	OpModule
+	OpJumpIfPassed
+	OpCallKw
+	OpUnpackArray
+	OpUnpackHash
+	OpMatchArray
+	OpMatchHash
+	OpNoMatch
+	OpLoop
+	OpEndLoop
+	OpUnwindLoop
 )
 
 type Definition struct {
@@ -75,32 +136,76 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}},
-	OpAdd:           {"OpAdd", []int{}},
-	OpPop:           {"OpPop", []int{}},
-	OpSub:           {"OpSub", []int{}},
-	OpMul:           {"OpMul", []int{}},
-	OpDiv:           {"OpDiv", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpGreaterThan:   {"OpGreaterThan", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
-	OpNull:          {"OpNull", []int{}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
-	OpArray:         {"OpArray", []int{2}},
-	OpIndex:         {"OpIndex", []int{}},
-	OpCall:          {"OpCall", []int{1}},
-	OpReturnValue:   {"OpReturnValue", []int{}},
-	OpReturn:        {"OpReturn", []int{}},
-	OpGetLocal:      {"OpGetLocal", []int{1}},
-	OpSetLocal:      {"OpSetLocal", []int{1}},
-	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpAdd:                {"OpAdd", []int{}},
+	OpPop:                {"OpPop", []int{}},
+	OpSub:                {"OpSub", []int{}},
+	OpMul:                {"OpMul", []int{}},
+	OpDiv:                {"OpDiv", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	OpJumpNotTruthy:      {"OpJumpNotTruthy", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpNull:               {"OpNull", []int{}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpArray:              {"OpArray", []int{2}},
+	OpIndex:              {"OpIndex", []int{}},
+	OpCall:               {"OpCall", []int{1}},
+	OpReturnValue:        {"OpReturnValue", []int{}},
+	OpReturn:             {"OpReturn", []int{}},
+	OpGetLocal:           {"OpGetLocal", []int{1}},
+	OpSetLocal:           {"OpSetLocal", []int{1}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{1}},
+	OpClosure:            {"OpClosure", []int{2}},
+	OpGetFree:            {"OpGetFree", []int{1}},
+	OpHash:               {"OpHash", []int{2}},
+	OpIterator:           {"OpIterator", []int{}},
+	OpIterNext:           {"OpIterNext", []int{2}},
+	OpSetFree:            {"OpSetFree", []int{1}},
+	OpSetIndex:           {"OpSetIndex", []int{}},
+	OpJumpNotTruthyOrPop: {"OpJumpNotTruthyOrPop", []int{2}},
+	OpJumpTruthyOrPop:    {"OpJumpTruthyOrPop", []int{2}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpMod:                {"OpMod", []int{}},
+	OpBitAnd:             {"OpBitAnd", []int{}},
+	OpBitOr:              {"OpBitOr", []int{}},
+	OpBitXor:             {"OpBitXor", []int{}},
+	OpShiftLeft:          {"OpShiftLeft", []int{}},
+	OpShiftRight:         {"OpShiftRight", []int{}},
+	OpBitNot:             {"OpBitNot", []int{}},
+	OpConcat:             {"OpConcat", []int{2}},
+	OpLessThan:           {"OpLessThan", []int{}},
+	OpLessThanOrEqual:    {"OpLessThanOrEqual", []int{}},
+	OpTry:                {"OpTry", []int{2}},
+	OpEndTry:             {"OpEndTry", []int{}},
+	OpThrow:              {"OpThrow", []int{}},
+	OpCatch:              {"OpCatch", []int{}},
+	OpImport:             {"OpImport", []int{2, 2}},
+	OpModule:             {"OpModule", []int{2}},
+	OpJumpIfPassed:       {"OpJumpIfPassed", []int{1, 2}},
+	OpCallKw:             {"OpCallKw", []int{1, 2}},
+	OpUnpackArray:        {"OpUnpackArray", []int{1, 1}},
+	OpUnpackHash:         {"OpUnpackHash", []int{2}},
+	OpMatchArray:         {"OpMatchArray", []int{1, 1}},
+	OpMatchHash:          {"OpMatchHash", []int{2}},
+	OpNoMatch:            {"OpNoMatch", []int{}},
+	OpLoop:               {"OpLoop", []int{}},
+	OpEndLoop:            {"OpEndLoop", []int{}},
+	OpUnwindLoop:         {"OpUnwindLoop", []int{}},
+}
+
+func (op Opcode) String() string {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Sprintf("Opcode(%d)", byte(op))
+	}
+	return def.Name
 }
 
 func Lookup(op byte) (*Definition, error) {
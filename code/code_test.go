@@ -13,6 +13,7 @@ func TestMake(t *testing.T) {
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534}, []byte{byte(OpClosure), 255, 254}},
 	}
 
 	for _, tt := range tests {
@@ -38,12 +39,18 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535),
+		Make(OpGetFree, 1),
+		Make(OpCallKw, 3, 65535),
 	}
 
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
+0009 OpClosure 65535
+0012 OpGetFree 1
+0014 OpCallKw 3 65535
 `
 
 	concatted := Instructions{}
@@ -65,6 +72,9 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
+		{OpJumpIfPassed, []int{255, 65535}, 3},
+		{OpUnpackArray, []int{3, 1}, 2},
+		{OpMatchHash, []int{65535}, 2},
 	}
 
 	for _, tt := range tests {
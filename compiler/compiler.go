@@ -2,10 +2,14 @@ package compiler
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/lqqyt2423/go-monkey/ast"
 	"github.com/lqqyt2423/go-monkey/code"
 	"github.com/lqqyt2423/go-monkey/object"
+	"github.com/lqqyt2423/go-monkey/parser"
+	"github.com/lqqyt2423/go-monkey/token"
 )
 
 type EmittedInstruction struct {
@@ -17,6 +21,26 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	positions           code.PosTable
+
+	loops []*loopContext
+	tries []tryContext
+}
+
+// loopContext tracks the jumps of the innermost loop being compiled.
+type loopContext struct {
+	continuePos int
+	breakJumps  []int
+	// tryDepth is how many try blocks enclose the loop.
+	tryDepth int
+}
+
+// tryContext is a try or catch block being compiled, which a return, break
+// or continue has to leave properly.
+type tryContext struct {
+	// handler is set while an OpTry handler is installed for the block.
+	handler bool
+	finally *ast.BlockStatement
 }
 
 type Compiler struct {
@@ -25,6 +49,41 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// pos is the position of the innermost node being compiled, recorded
+	// against every instruction emitted for it.
+	pos token.Position
+
+	// modules is shared with the compilers of the imported modules, and
+	// comes with the symbol table.
+	modules *moduleCache
+	// exports are the names a module exports, in order.
+	exports []string
+	// numTemps counts the variables made by defineTemp.
+	numTemps int
+}
+
+// moduleCache holds the modules compiled for a program by file, and the
+// ones being compiled to catch import cycles.
+type moduleCache struct {
+	compiled map[string]compiledModule
+	loading  []string
+	// parse reads the file of a module.
+	parse func(file string) (*ast.Program, error)
+}
+
+func newModuleCache() *moduleCache {
+	return &moduleCache{
+		compiled: make(map[string]compiledModule),
+		parse:    parser.ParseFile,
+	}
+}
+
+// compiledModule is a module function in the constant pool, and the global
+// slot the module is kept in once it has run.
+type compiledModule struct {
+	constIndex int
+	slot       int
 }
 
 func New() *Compiler {
@@ -35,24 +94,44 @@ func New() *Compiler {
 	return &Compiler{
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{CompilationScope{}},
+		modules:     symbolTable.moduleCache(),
 	}
 }
 
+// NewWithState returns a compiler that continues the programs compiled
+// with s and constants, reusing the globals and modules they compiled.
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	c := New()
 	c.symbolTable = s
 	c.constants = constants
+	c.modules = s.moduleCache()
 	return c
 }
 
+// SetModuleParser sets how the files of imported modules are parsed, which
+// is parser.ParseFile by default. Macros are expanded before compiling, so
+// the parser has to expand the ones a module defines.
+func (c *Compiler) SetModuleParser(parse func(file string) (*ast.Program, error)) {
+	c.modules.parse = parse
+}
+
 type ByteCode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Positions    code.PosTable
+	// Globals names the global slots, for errors about them.
+	Globals []string
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.IsValid() {
+		defer func(outer token.Position) { c.pos = outer }(c.pos)
+		c.pos = pos
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
+		c.defineFunctions(node.Statements)
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -60,17 +139,53 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.LetStatement:
+		if node.Pattern != nil {
+			err := c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			err = c.compilePattern(node.Pattern)
+			if err != nil {
+				return err
+			}
+			if node.Exported {
+				for _, ident := range node.Names() {
+					c.exports = append(c.exports, ident.Value)
+				}
+			}
+			break
+		}
+		// a function is bound before its body is compiled so it can call itself
+		_, isFunction := node.Value.(*ast.FunctionLiteral)
+		var symbol Symbol
+		if isFunction {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		if !isFunction {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+		c.storeSymbol(symbol)
+		if node.Exported {
+			c.exports = append(c.exports, node.Name.Value)
+		}
+	case *ast.ImportStatement:
+		err := c.Compile(node.Import)
+		if err != nil {
+			return err
 		}
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
+	case *ast.ImportExpression:
+		mod, err := c.compileModule(node)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpImport, mod.constIndex, mod.slot)
 	case *ast.BlockStatement:
+		c.defineFunctions(node.Statements)
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -94,12 +209,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
-		if len(c.currentInstructions()) == beforePos {
-			c.emit(code.OpNull)
-		}
+		c.keepBlockValue(beforePos)
 
 		jumpPos := c.emit(code.OpJump, 0)
 		afterConsequencePos := len(c.currentInstructions())
@@ -113,29 +223,120 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
-			if len(c.currentInstructions()) == beforePos {
-				c.emit(code.OpNull)
-			}
+			c.keepBlockValue(beforePos)
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.WhileStatement:
+		c.emit(code.OpLoop)
+		loopStart := len(c.currentInstructions())
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		exitJumpPos := c.emit(code.OpJumpNotTruthy, 0)
+
+		c.enterLoop(loopStart)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+		c.leaveLoop()
+		c.changeOperand(exitJumpPos, len(c.currentInstructions()))
+		c.emit(code.OpEndLoop)
+		// like the evaluator, the loop is a statement whose value is null
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIterator)
+		c.emit(code.OpLoop)
+
+		// OpIterNext pushes the key and then the value, or pops the
+		// iterator and jumps past the loop once it is exhausted
+		loopStart := len(c.currentInstructions())
+		exitJumpPos := c.emit(code.OpIterNext, 0)
+		c.storeSymbol(c.symbolTable.Define(node.Value.Value))
+		if node.Key != nil {
+			c.storeSymbol(c.symbolTable.Define(node.Key.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		c.enterLoop(loopStart)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+		// break leaves the iterator on the stack, so it lands on this pop
+		c.leaveLoop()
+		c.emit(code.OpPop)
+		c.changeOperand(exitJumpPos, len(c.currentInstructions()))
+		c.emit(code.OpEndLoop)
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.errorf(node, "break outside loop")
+		}
+		err := c.exitTries(loop.tryDepth)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpUnwindLoop)
+		pos := c.emit(code.OpJump, 0)
+		loop.breakJumps = append(loop.breakJumps, pos)
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.errorf(node, "continue outside loop")
+		}
+		err := c.exitTries(loop.tryDepth)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpUnwindLoop)
+		c.emit(code.OpJump, loop.continuePos)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.TryExpression:
+		err := c.compileTry(node)
+		if err != nil {
+			return err
+		}
+	case *ast.MatchExpression:
+		err := c.compileMatchExpression(node)
+		if err != nil {
+			return err
+		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
+		if node.Operator == "&&" || node.Operator == "||" {
+			err := c.Compile(node.Left)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(node.Left)
+			op := code.OpJumpNotTruthyOrPop
+			if node.Operator == "||" {
+				op = code.OpJumpTruthyOrPop
+			}
+			jumpPos := c.emit(op, 0)
+			err = c.Compile(node.Right)
 			if err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
+			c.changeOperand(jumpPos, len(c.currentInstructions()))
 			return nil
 		}
-
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
@@ -153,14 +354,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
 		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
+			return c.errorf(node, "unknown operator: %s", node.Operator)
 		}
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
@@ -172,12 +391,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
+			return c.errorf(node, "unknown operator: %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInteger{Value: node.Big}
+		}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -187,17 +414,52 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			err := c.Compile(part)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpConcat, len(node.Parts))
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return c.errorf(node, "undefined variable %s", node.Value)
 		}
-		if symbol.Scope == BuiltinScope {
-			c.emit(code.OpGetBuiltin, symbol.Index)
-		} else if symbol.Scope == GlobalScope {
-			c.emit(code.OpGetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpGetLocal, symbol.Index)
+		c.loadSymbol(symbol)
+	case *ast.AssignExpression:
+		switch target := node.Target.(type) {
+		case *ast.Identifier:
+			symbol, ok := c.symbolTable.Resolve(target.Value)
+			if !ok {
+				return c.errorf(target, "undefined variable %s", target.Value)
+			}
+			if symbol.Scope == BuiltinScope {
+				return c.errorf(target, "cannot assign to builtin %s", target.Value)
+			}
+			err := c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.storeSymbol(symbol)
+			c.loadSymbol(symbol)
+		case *ast.IndexExpression:
+			err := c.Compile(target.Left)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(target.Index)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpSetIndex)
+		default:
+			return c.errorf(node, "invalid assignment target: %s", node.Target.String())
 		}
 	case *ast.ArrayLiteral:
 		for _, ele := range node.Elements {
@@ -207,6 +469,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		keys := make([]ast.Expression, 0, len(node.Pairs))
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// map iteration order is random, sort to emit stable instructions
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -222,7 +504,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
-		err := c.Compile(node.Body)
+		if node.Rest != nil {
+			c.symbolTable.Define(node.Rest.Value)
+		}
+		numDefaults, err := c.compileDefaults(node.Defaults)
+		if err != nil {
+			return err
+		}
+		for i, pattern := range node.Patterns {
+			if pattern == nil {
+				continue
+			}
+			c.emit(code.OpGetLocal, i)
+			err = c.compilePattern(pattern)
+			if err != nil {
+				return err
+			}
+		}
+		err = c.Compile(node.Body)
 		if err != nil {
 			return err
 		}
@@ -232,21 +531,54 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if !c.lastInstructionIs(code.OpReturnValue) {
 			c.emit(code.OpReturn)
 		}
+		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		locals := c.symbolTable.locals
+		positions := c.scopes[c.scopeIndex].positions
 		instructions := c.leaveScope()
+
+		captures := make([]object.Capture, len(freeSymbols))
+		for i, s := range freeSymbols {
+			captures[i].Name = s.Name
+			captures[i].Index = s.Index
+			if s.Scope == FreeScope {
+				captures[i].Scope = object.CaptureFree
+			} else {
+				captures[i].Scope = object.CaptureLocal
+			}
+		}
+
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
+			Locals:        locals,
 			NumParameters: len(node.Parameters),
+			Parameters:    parameterNames(node.Parameters),
+			NumDefaults:   numDefaults,
+			Variadic:      node.Rest != nil,
+			Captures:      captures,
+			Positions:     positions,
+			Name:          node.Name,
 		}
-		c.emit(code.OpConstant, c.addConstant(compiledFn))
+		c.emit(code.OpClosure, c.addConstant(compiledFn))
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
+		err = c.exitTries(0)
+		if err != nil {
+			return err
+		}
 		c.emit(code.OpReturnValue)
+	case *ast.MacroLiteral:
+		// macros are expanded before compiling, which removes the ones in
+		// top-level let statements
+		return c.errorf(node, "macro literal only allowed in top-level let")
 	case *ast.CallExpression:
+		if ident, ok := node.Function.(*ast.Identifier); ok && ident.Value == "quote" {
+			return c.errorf(node, "quote only allowed in macros")
+		}
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
@@ -257,16 +589,247 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
-		c.emit(code.OpCall, len(node.Arguments))
+		if len(node.Keywords) == 0 {
+			c.emit(code.OpCall, len(node.Arguments))
+			break
+		}
+		names := &object.Array{}
+		for _, kw := range node.Keywords {
+			err = c.Compile(kw.Value)
+			if err != nil {
+				return err
+			}
+			names.Elements = append(names.Elements, &object.String{Value: kw.Name.Value})
+		}
+		c.emit(code.OpCallKw, len(node.Arguments)+len(node.Keywords), c.addConstant(names))
+	}
+
+	return nil
+}
+
+// compileDefaults emits the prologue that computes the default of each
+// parameter the caller did not pass, and returns how many there are.
+func (c *Compiler) compileDefaults(defaults []ast.Expression) (int, error) {
+	numDefaults := 0
+	for i, def := range defaults {
+		if def == nil {
+			continue
+		}
+		numDefaults++
+		jumpPos := c.emit(code.OpJumpIfPassed, i, 0)
+		err := c.Compile(def)
+		if err != nil {
+			return 0, err
+		}
+		c.emit(code.OpSetLocal, i)
+		c.changeOperand(jumpPos, i, len(c.currentInstructions()))
 	}
+	return numDefaults, nil
+}
+
+// compilePattern binds the names of pattern to the parts of the value on
+// top of the stack, which it pops.
+func (c *Compiler) compilePattern(pattern ast.Pattern) error {
+	defer func(outer token.Position) { c.pos = outer }(c.pos)
+	c.pos = pattern.Pos()
+
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		c.emit(code.OpPop)
+		return nil
+	case *ast.Identifier:
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+		return nil
+	}
+	targets, err := c.emitSplit(pattern, false)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		err := c.compilePattern(target)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileMatchExpression tries the arms in turn, jumping to the next one
+// when the pattern does not match or the guard fails, and raises a
+// MatchError after the last.
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression) error {
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+	subject := c.defineTemp()
+	c.storeSymbol(subject)
+
+	var endJumps []int
+	for _, arm := range node.Arms {
+		var failJumps []int
+		// each arm binds its names in a block of its own
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		err := c.compileMatchArm(arm, subject, &failJumps)
+		c.symbolTable = c.symbolTable.Outer
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 0))
+		for _, pos := range failJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+	c.loadSymbol(subject)
+	c.emit(code.OpNoMatch)
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
 
+// compileMatchArm leaves the value of the body of arm on the stack if its
+// pattern and guard match subject, appending the jumps taken when they do
+// not to failJumps.
+func (c *Compiler) compileMatchArm(arm *ast.MatchArm, subject Symbol, failJumps *[]int) error {
+	err := c.compileMatch(arm.Pattern, subject, failJumps)
+	if err != nil {
+		return err
+	}
+	if arm.Guard != nil {
+		err := c.Compile(arm.Guard)
+		if err != nil {
+			return err
+		}
+		*failJumps = append(*failJumps, c.emit(code.OpJumpNotTruthy, 0))
+	}
+	beforePos := len(c.currentInstructions())
+	err = c.Compile(arm.Body)
+	if err != nil {
+		return err
+	}
+	c.keepBlockValue(beforePos)
 	return nil
 }
 
+// compileMatch binds the names of pattern to the parts of the value in
+// subject, appending the jumps taken when it does not match to failJumps.
+// The stack is left as it was on either path.
+func (c *Compiler) compileMatch(pattern ast.Pattern, subject Symbol, failJumps *[]int) error {
+	defer func(outer token.Position) { c.pos = outer }(c.pos)
+	c.pos = pattern.Pos()
+
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.Identifier:
+		c.loadSymbol(subject)
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+		return nil
+	case *ast.LiteralPattern:
+		c.loadSymbol(subject)
+		err := c.Compile(pattern.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpEqual)
+		*failJumps = append(*failJumps, c.emit(code.OpJumpNotTruthy, 0))
+		return nil
+	}
+
+	c.loadSymbol(subject)
+	targets, err := c.emitSplit(pattern, true)
+	if err != nil {
+		return err
+	}
+	*failJumps = append(*failJumps, c.emit(code.OpJumpNotTruthy, 0))
+
+	// the parts are popped into variables right away, so that a failed
+	// match further on leaves nothing on the stack
+	parts := make([]Symbol, len(targets))
+	for i, target := range targets {
+		switch target := target.(type) {
+		case *ast.WildcardPattern:
+			c.emit(code.OpPop)
+		case *ast.Identifier:
+			c.storeSymbol(c.symbolTable.Define(target.Value))
+		default:
+			parts[i] = c.defineTemp()
+			c.storeSymbol(parts[i])
+		}
+	}
+	for i, target := range targets {
+		switch target.(type) {
+		case *ast.WildcardPattern, *ast.Identifier:
+			continue
+		}
+		err := c.compileMatch(target, parts[i], failJumps)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitSplit emits the instruction that splits the value on top of the
+// stack into the parts of an array or hash pattern, pushed so that the
+// first is on top, and returns the patterns for them. For a match, the
+// instruction also pushes whether the value has the pattern's shape, and
+// nothing else when it does not.
+func (c *Compiler) emitSplit(pattern ast.Pattern, match bool) ([]ast.Pattern, error) {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		if len(pattern.Elements) > 255 {
+			return nil, c.errorf(pattern, "too many elements in array pattern")
+		}
+		hasRest := 0
+		targets := pattern.Elements
+		if pattern.Rest != nil {
+			hasRest = 1
+			targets = append(targets[:len(targets):len(targets)], pattern.Rest)
+		}
+		op := code.OpUnpackArray
+		if match {
+			op = code.OpMatchArray
+		}
+		c.emit(op, len(pattern.Elements), hasRest)
+		return targets, nil
+	case *ast.HashPattern:
+		keys := &object.Array{}
+		for _, key := range pattern.KeyNames() {
+			keys.Elements = append(keys.Elements, &object.String{Value: key})
+		}
+		op := code.OpUnpackHash
+		if match {
+			op = code.OpMatchHash
+		}
+		c.emit(op, c.addConstant(keys))
+		return pattern.Targets, nil
+	}
+	return nil, c.errorf(pattern, "unexpected pattern %s", pattern)
+}
+
+// defineTemp defines a variable no identifier can name, for a value the
+// compiled code keeps for a while.
+func (c *Compiler) defineTemp() Symbol {
+	c.numTemps++
+	return c.symbolTable.Define(fmt.Sprintf("$%d", c.numTemps))
+}
+
+func parameterNames(params []*ast.Identifier) []string {
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.Value
+	}
+	return names
+}
+
 func (c *Compiler) Bytecode() *ByteCode {
 	return &ByteCode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Globals:      c.symbolTable.globalNames(),
 	}
 }
 
@@ -279,9 +842,29 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	c.addPosition(pos)
 	return pos
 }
 
+// addPosition maps the instruction at offset to the current position,
+// dropping entries left behind by instructions removed after them.
+func (c *Compiler) addPosition(offset int) {
+	scope := &c.scopes[c.scopeIndex]
+	positions := scope.positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= offset {
+		positions = positions[:len(positions)-1]
+	}
+	if len(positions) == 0 || positions[len(positions)-1].Pos != c.pos {
+		positions = append(positions, code.SourcePos{Offset: offset, Pos: c.pos})
+	}
+	scope.positions = positions
+}
+
+// errorf reports an error found compiling node.
+func (c *Compiler) errorf(node ast.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", node.Pos(), fmt.Sprintf(format, args...))
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -323,9 +906,9 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction := code.Make(op, operands...)
 	c.replaceInstruction(opPos, newInstruction)
 }
 
@@ -343,6 +926,238 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return ins
 }
 
+// keepBlockValue leaves the value of the block compiled since beforePos on
+// the stack: the value of its trailing expression statement, or null when
+// the block is empty or ends with any other statement.
+func (c *Compiler) keepBlockValue(beforePos int) {
+	if len(c.currentInstructions()) > beforePos && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) enterLoop(continuePos int) {
+	scope := &c.scopes[c.scopeIndex]
+	loop := &loopContext{continuePos: continuePos, tryDepth: len(scope.tries)}
+	scope.loops = append(scope.loops, loop)
+}
+
+// leaveLoop points every break of the innermost loop just past its end.
+func (c *Compiler) leaveLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	afterLoopPos := len(c.currentInstructions())
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, afterLoopPos)
+	}
+}
+
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// compileTry compiles a try expression. An error in the try block jumps to
+// the catch block, or with no catch, to a copy of the finally block that
+// rethrows the error afterwards; an error in the catch block goes there
+// too. Both ways out of a successful try or catch run the finally block
+// and leave its value on the stack.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 0)
+	err := c.compileTryBlock(node.Block, tryContext{handler: true, finally: node.Finally})
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+	jumpPos := c.emit(code.OpJump, 0)
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	var endJumps []int
+	if node.Catch != nil {
+		c.emit(code.OpCatch)
+		if node.CatchParam != nil {
+			c.storeSymbol(c.symbolTable.Define(node.CatchParam.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+		catchTryPos := -1
+		if node.Finally != nil {
+			catchTryPos = c.emit(code.OpTry, 0)
+		}
+		err := c.compileTryBlock(node.Catch, tryContext{handler: node.Finally != nil, finally: node.Finally})
+		if err != nil {
+			return err
+		}
+		if node.Finally != nil {
+			c.emit(code.OpEndTry)
+			endJumps = append(endJumps, c.emit(code.OpJump, 0))
+			c.changeOperand(catchTryPos, len(c.currentInstructions()))
+		}
+	}
+	if node.Finally != nil {
+		// the error is on the stack, under whatever the finally block leaves
+		err := c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	if node.Finally != nil {
+		return c.compileFinally(node.Finally)
+	}
+	return nil
+}
+
+// compileModule compiles the file node imports into a function that runs
+// it and returns its module, unless it has been compiled already. The
+// module's globals get slots of their own in the program's globals store.
+func (c *Compiler) compileModule(node *ast.ImportExpression) (compiledModule, error) {
+	file := node.File()
+	if mod, ok := c.modules.compiled[file]; ok {
+		return mod, nil
+	}
+	for i, f := range c.modules.loading {
+		if f == file {
+			cycle := append(c.modules.loading[i:len(c.modules.loading):len(c.modules.loading)], file)
+			return compiledModule{}, c.errorf(node, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	program, err := c.modules.parse(file)
+	if err != nil {
+		return compiledModule{}, c.errorf(node, "%s", err)
+	}
+
+	c.modules.loading = append(c.modules.loading, file)
+	defer func() { c.modules.loading = c.modules.loading[:len(c.modules.loading)-1] }()
+	mc := &Compiler{
+		constants:   c.constants,
+		symbolTable: NewModuleSymbolTable(c.symbolTable),
+		scopes:      []CompilationScope{CompilationScope{}},
+		modules:     c.modules,
+	}
+	err = mc.Compile(program)
+	if err != nil {
+		return compiledModule{}, err
+	}
+	mod := compiledModule{slot: mc.symbolTable.defineSlot(file)}
+	for _, name := range mc.exports {
+		symbol, _ := mc.symbolTable.Resolve(name)
+		mc.emit(code.OpConstant, mc.addConstant(&object.String{Value: name}))
+		mc.loadSymbol(symbol)
+	}
+	mc.emit(code.OpHash, len(mc.exports)*2)
+	mc.emit(code.OpModule, mod.slot)
+	mc.emit(code.OpReturnValue)
+
+	c.constants = mc.constants
+	fn := &object.CompiledFunction{
+		Instructions: mc.currentInstructions(),
+		Positions:    mc.scopes[0].positions,
+		Name:         file,
+	}
+	mod.constIndex = c.addConstant(fn)
+	c.modules.compiled[file] = mod
+	return mod, nil
+}
+
+// compileTryBlock compiles the try or catch block of a try expression,
+// leaving its value on the stack.
+func (c *Compiler) compileTryBlock(block *ast.BlockStatement, try tryContext) error {
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+	beforePos := len(c.currentInstructions())
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	c.keepBlockValue(beforePos)
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+	return nil
+}
+
+// compileFinally compiles a finally block, whose value is discarded.
+func (c *Compiler) compileFinally(block *ast.BlockStatement) error {
+	beforePos := len(c.currentInstructions())
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	c.keepBlockValue(beforePos)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// exitTries leaves the try and catch blocks entered since depth, innermost
+// first, for a jump out of them: their handlers are removed and their
+// finally blocks run.
+func (c *Compiler) exitTries(depth int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+	for i := len(tries) - 1; i >= depth; i-- {
+		// a jump out of the finally block only leaves the blocks around it
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		if tries[i].handler {
+			c.emit(code.OpEndTry)
+		}
+		if tries[i].finally != nil {
+			err := c.compileFinally(tries[i].finally)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// defineFunctions binds the names of all functions declared with let in
+// stmts up front, so functions in the same scope can call each other
+// regardless of declaration order.
+func (c *Compiler) defineFunctions(stmts []ast.Statement) {
+	for _, s := range stmts {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok && let.Name != nil {
+			c.symbolTable.Define(let.Name.Value)
+		}
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
@@ -4,6 +4,8 @@ package compiler
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/lqqyt2423/go-monkey/ast"
@@ -80,6 +82,44 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	for operator, op := range map[string]code.Opcode{
+		"%":  code.OpMod,
+		"&":  code.OpBitAnd,
+		"|":  code.OpBitOr,
+		"^":  code.OpBitXor,
+		"<<": code.OpShiftLeft,
+		">>": code.OpShiftRight,
+	} {
+		tests = append(tests, compilerTestCase{
+			input:             "1 " + operator + " 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(op),
+				code.Make(code.OpPop),
+			},
+		})
 	}
 
 	runCompilerTests(t, tests)
@@ -135,11 +175,41 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" < "b"`,
+			expectedConstants: []interface{}{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -324,6 +394,26 @@ func TestStringExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = 1; "a${x}b"`,
+			expectedConstants: []interface{}{1, "a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConcat, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -367,6 +457,51 @@ func TestArrayLiterals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{"a": 2, "b": 4, "c": 6}`,
+			expectedConstants: []interface{}{"a", 2, "b", 4, "c", 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpHash, 6),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{"a": 2 + 3, "b": 4 * 5}`,
+			expectedConstants: []interface{}{"a", 2, 3, "b", 4, 5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpMul),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -384,6 +519,18 @@ func TestIndexExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{"a": 2}["a"]`,
+			expectedConstants: []interface{}{"a", 2, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -404,7 +551,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -421,7 +568,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -438,7 +585,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -450,7 +597,165 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestVariadicFunctions(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("fn(a, ...rest) { rest }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn, ok := compiler.Bytecode().Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant not a function. got=%T", compiler.Bytecode().Constants[0])
+	}
+	if !fn.Variadic || fn.NumParameters != 1 || fn.NumLocals != 2 {
+		t.Fatalf("wrong function. Variadic=%t, NumParameters=%d, NumLocals=%d", fn.Variadic, fn.NumParameters, fn.NumLocals)
+	}
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpReturnValue),
+	}, fn.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestDefaultParameters(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("fn(a, b = 2) { b }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn, ok := compiler.Bytecode().Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant not a function. got=%T", compiler.Bytecode().Constants[1])
+	}
+	if fn.NumParameters != 2 || fn.NumDefaults != 1 || fmt.Sprint(fn.Parameters) != "[a b]" {
+		t.Fatalf("wrong function. NumParameters=%d, NumDefaults=%d, Parameters=%q", fn.NumParameters, fn.NumDefaults, fn.Parameters)
+	}
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpJumpIfPassed, 1, 9),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpReturnValue),
+	}, fn.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestKeywordArguments(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("len(1, b: 2)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpGetBuiltin, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpCallKw, 2, 2),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	names, ok := bytecode.Constants[2].(*object.Array)
+	if !ok || len(names.Elements) != 1 || names.Elements[0].Inspect() != `"b"` {
+		t.Fatalf("wrong keyword names constant: %+v", bytecode.Constants[2])
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("let [a, {b}, ...c] = []; fn([d]) { d }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpArray, 0),
+		code.Make(code.OpUnpackArray, 2, 1),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpUnpackHash, 0),
+		code.Make(code.OpSetGlobal, 1),
+		code.Make(code.OpSetGlobal, 2),
+		code.Make(code.OpClosure, 1),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	keys, ok := bytecode.Constants[0].(*object.Array)
+	if !ok || len(keys.Elements) != 1 || keys.Elements[0].Inspect() != `"b"` {
+		t.Fatalf("wrong hash pattern keys constant: %+v", bytecode.Constants[0])
+	}
+
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	if fn.NumParameters != 1 || fn.NumLocals != 2 {
+		t.Fatalf("wrong function. NumParameters=%d, NumLocals=%d", fn.NumParameters, fn.NumLocals)
+	}
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpUnpackArray, 1, 0),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpReturnValue),
+	}, fn.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match (1) { 2 => 3, [a] => a }",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
 				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpEqual),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0016
+				code.Make(code.OpConstant, 2),
+				// 0019
+				code.Make(code.OpJump, 44),
+				// 0022
+				code.Make(code.OpGetGlobal, 0),
+				// 0025
+				code.Make(code.OpMatchArray, 1, 0),
+				// 0028
+				code.Make(code.OpJumpNotTruthy, 40),
+				// 0031
+				code.Make(code.OpSetGlobal, 1),
+				// 0034
+				code.Make(code.OpGetGlobal, 1),
+				// 0037
+				code.Make(code.OpJump, 44),
+				// 0040
+				code.Make(code.OpGetGlobal, 0),
+				// 0043
+				code.Make(code.OpNoMatch),
+				// 0044
 				code.Make(code.OpPop),
 			},
 		},
@@ -471,7 +776,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), //被编译的函数
+				code.Make(code.OpClosure, 1), //被编译的函数
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
@@ -489,7 +794,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), // 被编译的函数
+				code.Make(code.OpClosure, 1), // 被编译的函数
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -508,7 +813,7 @@ func TestFunctionCalls(t *testing.T) {
 				24,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -530,7 +835,7 @@ func TestFunctionCalls(t *testing.T) {
 				26,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -553,7 +858,7 @@ func TestFunctionCalls(t *testing.T) {
 				24,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -580,7 +885,7 @@ func TestFunctionCalls(t *testing.T) {
 				26,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -676,6 +981,12 @@ func testConstants(
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+		case float64:
+			result, ok := actual[i].(*object.Float)
+			if !ok || result.Value != constant {
+				return fmt.Errorf("constant %d - not Float %g: %T (%+v)",
+					i, constant, actual[i], actual[i])
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -811,7 +1122,7 @@ func TestLetStatementScopes(t *testing.T) {
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -832,7 +1143,7 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -859,7 +1170,7 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -892,8 +1203,128 @@ func TestBuiltins(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+            fn(a) {
+                fn(b) {
+                    a + b
+                }
+            }
+            `,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            fn(a) {
+                fn(b) {
+                    fn(c) {
+                        a + b + c
+                    }
+                }
+            };
+            `,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            let global = 55;
+
+            fn() {
+                let a = 66;
+
+                fn() {
+                    let b = 77;
+
+                    fn() {
+                        let c = 88;
+
+                        global + a + b + c;
+                    }
+                }
+            }
+            `,
+			expectedConstants: []interface{}{
+				55,
+				66,
+				77,
+				88,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpClosure, 4),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpClosure, 5),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 6),
 				code.Make(code.OpPop),
 			},
 		},
@@ -901,3 +1332,622 @@ func TestBuiltins(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func TestClosureCaptures(t *testing.T) {
+	program := parse(`
+    fn(a) {
+        let b = 1;
+        fn() {
+            fn() { b + a }
+        }
+    }
+    `)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := [][]object.Capture{
+		{{Scope: object.CaptureFree, Index: 0, Name: "b"}, {Scope: object.CaptureFree, Index: 1, Name: "a"}},
+		{{Scope: object.CaptureLocal, Index: 1, Name: "b"}, {Scope: object.CaptureLocal, Index: 0, Name: "a"}},
+		{},
+	}
+
+	constants := compiler.Bytecode().Constants
+	if len(constants) != len(expected)+1 {
+		t.Fatalf("wrong number of constants. got=%d, want=%d",
+			len(constants), len(expected)+1)
+	}
+
+	for i, want := range expected {
+		fn, ok := constants[i+1].(*object.CompiledFunction)
+		if !ok {
+			t.Fatalf("constant %d - not a function: %T", i+1, constants[i+1])
+		}
+		if len(fn.Captures) != len(want) {
+			t.Fatalf("constant %d - wrong number of captures. got=%d, want=%d",
+				i+1, len(fn.Captures), len(want))
+		}
+		for j, capture := range want {
+			if fn.Captures[j] != capture {
+				t.Errorf("constant %d - wrong capture %d. got=%+v, want=%+v",
+					i+1, j, fn.Captures[j], capture)
+			}
+		}
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+            let countDown = fn(x) { countDown(x - 1); };
+            countDown(1);
+            `,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            let wrapper = fn() {
+                let countDown = fn(x) { countDown(x - 1); };
+                countDown(1);
+            };
+            wrapper();
+            `,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            let isEven = fn(n) { isOdd(n) };
+            let isOdd = fn(n) { isEven(n) };
+            `,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `try { 1 } catch (e) { e }; throw 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 10),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpJump, 17),
+				// 0010
+				code.Make(code.OpCatch),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpConstant, 1),
+				// 0021
+				code.Make(code.OpThrow),
+			},
+		},
+		{
+			input:             `try { 1 } finally { 2 }`,
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 10),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpJump, 15),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpThrow),
+				// 0015
+				code.Make(code.OpConstant, 2),
+				// 0018
+				code.Make(code.OpPop),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { 10 }; 3333;`,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpLoop),
+				// 0001
+				code.Make(code.OpTrue),
+				// 0002
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0005
+				code.Make(code.OpConstant, 0),
+				// 0008
+				code.Make(code.OpPop),
+				// 0009
+				code.Make(code.OpJump, 1),
+				// 0012
+				code.Make(code.OpEndLoop),
+				// 0013
+				code.Make(code.OpNull),
+				// 0014
+				code.Make(code.OpPop),
+				// 0015
+				code.Make(code.OpConstant, 1),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `while (true) { if (false) { break; } continue; }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpLoop),
+				// 0001
+				code.Make(code.OpTrue),
+				// 0002
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0005
+				code.Make(code.OpFalse),
+				// 0006
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0009
+				code.Make(code.OpUnwindLoop),
+				// 0010
+				code.Make(code.OpJump, 26),
+				// 0013
+				code.Make(code.OpNull),
+				// 0014
+				code.Make(code.OpJump, 18),
+				// 0017
+				code.Make(code.OpNull),
+				// 0018
+				code.Make(code.OpPop),
+				// 0019
+				code.Make(code.OpUnwindLoop),
+				// 0020
+				code.Make(code.OpJump, 1),
+				// 0023
+				code.Make(code.OpJump, 1),
+				// 0026
+				code.Make(code.OpEndLoop),
+				// 0027
+				code.Make(code.OpNull),
+				// 0028
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `for (x in [1]) { x }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIterator),
+				// 0007
+				code.Make(code.OpLoop),
+				// 0008
+				code.Make(code.OpIterNext, 23),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpPop),
+				// 0015
+				code.Make(code.OpGetGlobal, 0),
+				// 0018
+				code.Make(code.OpPop),
+				// 0019
+				code.Make(code.OpJump, 8),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpEndLoop),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `for (k, v in {}) { break; }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpHash, 0),
+				// 0003
+				code.Make(code.OpIterator),
+				// 0004
+				code.Make(code.OpLoop),
+				// 0005
+				code.Make(code.OpIterNext, 22),
+				// 0008
+				code.Make(code.OpSetGlobal, 0),
+				// 0011
+				code.Make(code.OpSetGlobal, 1),
+				// 0014
+				code.Make(code.OpUnwindLoop),
+				// 0015
+				code.Make(code.OpJump, 21),
+				// 0018
+				code.Make(code.OpJump, 5),
+				// 0021
+				code.Make(code.OpPop),
+				// 0022
+				code.Make(code.OpEndLoop),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = 1; x = 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let x = 1; x = 2; }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { fn() { a = 1 } }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let a = [1]; a[0] = 2;`,
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"x = 1", "1:1: undefined variable x"},
+		{"fn() { y = 1 }", "1:8: undefined variable y"},
+		{"len = 1", "1:1: cannot assign to builtin len"},
+	}
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("%q: expected compiler error", tt.input)
+		}
+		if err.Error() != tt.wantErr {
+			t.Fatalf("%q: compiler error want %q, but got %q", tt.input, tt.wantErr, err.Error())
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"let f = fn() { let m = macro(x) { x }; };", "1:24: macro literal only allowed in top-level let"},
+		{"let m = macro(x) { x };", "1:9: macro literal only allowed in top-level let"},
+		{"let q = quote(1 + 2);", "1:14: quote only allowed in macros"},
+	}
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("%q: expected compiler error", tt.input)
+		}
+		if err.Error() != tt.wantErr {
+			t.Fatalf("%q: compiler error want %q, but got %q", tt.input, tt.wantErr, err.Error())
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, dir, "lib.mk", "let a = 1; export let b = a;")
+	input := fmt.Sprintf(`import "%[1]s/lib.mk"; import("%[1]s/lib.mk");`, dir)
+	tests := []compilerTestCase{
+		{
+			input: input,
+			expectedConstants: []interface{}{
+				1,
+				"b",
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpSetGlobal, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpHash, 2),
+					code.Make(code.OpModule, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpImport, 2, 2),
+				// 0005
+				code.Make(code.OpSetGlobal, 3),
+				// 0008
+				code.Make(code.OpImport, 2, 2),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, dir, "a.mk", `import "b.mk";`)
+	writeModule(t, dir, "b.mk", `import "a.mk";`)
+	writeModule(t, dir, "c.mk", "x")
+
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{
+			fmt.Sprintf(`import "%s/a.mk"`, dir),
+			fmt.Sprintf("%[1]s/b.mk:1:1: import cycle: %[1]s/a.mk -> %[1]s/b.mk -> %[1]s/a.mk", dir),
+		},
+		{fmt.Sprintf(`import "%s/c.mk"`, dir), fmt.Sprintf("%s/c.mk:1:1: undefined variable x", dir)},
+		{fmt.Sprintf(`1; import("%s/d.mk")`, dir), fmt.Sprintf("1:4: open %s/d.mk: no such file or directory", dir)},
+	}
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("%q: expected compiler error", tt.input)
+		}
+		if err.Error() != tt.wantErr {
+			t.Fatalf("%q: compiler error want %q, but got %q", tt.input, tt.wantErr, err.Error())
+		}
+	}
+}
+
+func writeModule(t *testing.T, dir, name, input string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(input), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `true && false; 1 || 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+				// 0006
+				code.Make(code.OpConstant, 0),
+				// 0009
+				code.Make(code.OpJumpTruthyOrPop, 15),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestPositionTable(t *testing.T) {
+	input := `let x = 1;
+x + 2;
+fn() {
+  x - 3
+}`
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+
+	tests := []struct {
+		instructions code.Instructions
+		positions    code.PosTable
+		op           code.Opcode
+		want         string
+	}{
+		{bytecode.Instructions, bytecode.Positions, code.OpSetGlobal, "1:1"},
+		{bytecode.Instructions, bytecode.Positions, code.OpAdd, "2:3"},
+		{bytecode.Instructions, bytecode.Positions, code.OpClosure, "3:1"},
+		{fn.Instructions, fn.Positions, code.OpSub, "4:5"},
+	}
+
+	for _, tt := range tests {
+		offset := findOpcode(tt.instructions, tt.op)
+		if offset < 0 {
+			t.Fatalf("%s not found in\n%s", tt.op, tt.instructions)
+		}
+		if got := tt.positions.Lookup(offset).String(); got != tt.want {
+			t.Errorf("%s: position want %s, but got %s", tt.op, tt.want, got)
+		}
+	}
+}
+
+// findOpcode returns the offset of the first op in ins, or -1.
+func findOpcode(ins code.Instructions, op code.Opcode) int {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return -1
+		}
+		if code.Opcode(ins[i]) == op {
+			return i
+		}
+		_, read := code.ReadOperands(def, ins[i+1:])
+		i += 1 + read
+	}
+	return -1
+}
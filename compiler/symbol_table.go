@@ -6,6 +6,7 @@ const (
 	GlobalScope  SymbolScope = "GLOBAL"
 	LocalScope   SymbolScope = "LOCAL"
 	BuiltinScope SymbolScope = "BUILTIN"
+	FreeScope    SymbolScope = "FREE"
 )
 
 type Symbol struct {
@@ -19,12 +20,48 @@ type SymbolTable struct {
 
 	store          map[string]Symbol
 	numDefinitions int
+	// locals names the local slots handed out, by slot.
+	locals []string
+	// globals names the global slots handed out, by slot. It is shared by
+	// the top-level tables of a program and of the modules it imports,
+	// which all use the one globals store.
+	globals *[]string
+	// modules caches the modules compiled for the program. Like globals,
+	// it is kept with the top-level table, so a module is compiled once
+	// for all the programs that share the table.
+	modules *moduleCache
+	// block is set on a table for a block within a function or program,
+	// whose names take slots from the table it is nested in.
+	block bool
+
+	// FreeSymbols holds the original symbols, as resolved in the enclosing
+	// table, of every free variable referenced from this scope.
+	FreeSymbols []Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{
-		store: make(map[string]Symbol),
+		store:   make(map[string]Symbol),
+		globals: new([]string),
+		modules: newModuleCache(),
+	}
+}
+
+// NewModuleSymbolTable returns the top-level table for a module imported
+// by the program s belongs to.
+func NewModuleSymbolTable(s *SymbolTable) *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	m := NewSymbolTable()
+	m.globals = s.globals
+	m.modules = s.modules
+	for _, symbol := range s.store {
+		if symbol.Scope == BuiltinScope {
+			m.store[symbol.Name] = symbol
+		}
 	}
+	return m
 }
 
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
@@ -33,25 +70,76 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// NewBlockSymbolTable returns a table for a block nested in outer. Names
+// defined in it are not visible outside the block.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
 func (s *SymbolTable) Define(name string) Symbol {
+	// rebinding a name reuses its slot, as Environment.Set does in the evaluator
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	frame := s
+	for frame.block {
+		frame = frame.Outer
+	}
 	symbol := Symbol{
 		Name:  name,
-		Index: s.numDefinitions,
+		Index: frame.numDefinitions,
 	}
-	if s.Outer == nil {
+	if frame.Outer == nil {
 		symbol.Scope = GlobalScope
+		symbol.Index = s.defineSlot(name)
 	} else {
 		symbol.Scope = LocalScope
+		frame.locals = append(frame.locals, name)
 	}
 	s.store[name] = symbol
-	s.numDefinitions++
+	frame.numDefinitions++
 	return symbol
 }
 
+// defineSlot hands out a global slot, for name or for a value no name is
+// bound to.
+func (s *SymbolTable) defineSlot(name string) int {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	*s.globals = append(*s.globals, name)
+	return len(*s.globals) - 1
+}
+
+// moduleCache returns the modules compiled for the program s belongs to.
+func (s *SymbolTable) moduleCache() *moduleCache {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s.modules
+}
+
+// globalNames names the global slots handed out so far, by slot.
+func (s *SymbolTable) globalNames() []string {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return append([]string(nil), *s.globals...)
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
-		return s.Outer.Resolve(name)
+		obj, ok = s.Outer.Resolve(name)
+		if !ok || s.block {
+			return obj, ok
+		}
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+		return s.defineFree(obj), true
 	}
 	return obj, ok
 }
@@ -61,3 +149,10 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	s.store[name] = symbol
 	return symbol
 }
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
+	return symbol
+}
@@ -185,3 +185,221 @@ func TestDefineResolveBuiltins(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+	firstLocal.Define("d")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+	secondLocal.Define("f")
+
+	tests := []struct {
+		table               *SymbolTable
+		expectedSymbols     []Symbol
+		expectedFreeSymbols []Symbol
+	}{
+		{
+			firstLocal,
+			[]Symbol{
+				Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+				Symbol{Name: "b", Scope: GlobalScope, Index: 1},
+				Symbol{Name: "c", Scope: LocalScope, Index: 0},
+				Symbol{Name: "d", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{},
+		},
+		{
+			secondLocal,
+			[]Symbol{
+				Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+				Symbol{Name: "b", Scope: GlobalScope, Index: 1},
+				Symbol{Name: "c", Scope: FreeScope, Index: 0},
+				Symbol{Name: "d", Scope: FreeScope, Index: 1},
+				Symbol{Name: "e", Scope: LocalScope, Index: 0},
+				Symbol{Name: "f", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{
+				Symbol{Name: "c", Scope: LocalScope, Index: 0},
+				Symbol{Name: "d", Scope: LocalScope, Index: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expectedSymbols {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v",
+					sym.Name, sym, result)
+			}
+		}
+
+		if len(tt.table.FreeSymbols) != len(tt.expectedFreeSymbols) {
+			t.Errorf("wrong number of free symbols. got=%d, want=%d",
+				len(tt.table.FreeSymbols), len(tt.expectedFreeSymbols))
+			continue
+		}
+
+		for i, sym := range tt.expectedFreeSymbols {
+			result := tt.table.FreeSymbols[i]
+			if result != sym {
+				t.Errorf("wrong free symbol. got=%+v, want=%+v",
+					result, sym)
+			}
+		}
+	}
+}
+
+func TestResolveUnresolvableFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+	secondLocal.Define("f")
+
+	expected := []Symbol{
+		Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+		Symbol{Name: "c", Scope: FreeScope, Index: 0},
+		Symbol{Name: "e", Scope: LocalScope, Index: 0},
+		Symbol{Name: "f", Scope: LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v",
+				sym.Name, sym, result)
+		}
+	}
+
+	expectedUnresolvable := []string{
+		"b",
+		"d",
+	}
+
+	for _, name := range expectedUnresolvable {
+		_, ok := secondLocal.Resolve(name)
+		if ok {
+			t.Errorf("name %s resolved, but was expected not to", name)
+		}
+	}
+}
+
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+	global.Define("b")
+
+	a := global.Define("a")
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+	if a != expected {
+		t.Errorf("expected a=%+v, got=%+v", expected, a)
+	}
+
+	shadowed := global.Define("len")
+	expected = Symbol{Name: "len", Scope: GlobalScope, Index: 2}
+	if shadowed != expected {
+		t.Errorf("expected len=%+v, got=%+v", expected, shadowed)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Resolve("b")
+	c := local.Define("c")
+	expected = Symbol{Name: "c", Scope: LocalScope, Index: 0}
+	if c != expected {
+		t.Errorf("expected c=%+v, got=%+v", expected, c)
+	}
+
+	nested := NewEnclosedSymbolTable(local)
+	nested.Resolve("c")
+	inner := nested.Define("c")
+	expected = Symbol{Name: "c", Scope: LocalScope, Index: 0}
+	if inner != expected {
+		t.Errorf("expected c=%+v, got=%+v", expected, inner)
+	}
+}
+
+func TestModuleSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+
+	module := NewModuleSymbolTable(NewEnclosedSymbolTable(global))
+	if _, ok := module.Resolve("a"); ok {
+		t.Errorf("name a should not resolve in the module")
+	}
+	if got, want := module.Define("b"), (Symbol{Name: "b", Scope: GlobalScope, Index: 1}); got != want {
+		t.Errorf("expected b=%+v, got=%+v", want, got)
+	}
+	if got, want := global.Define("c"), (Symbol{Name: "c", Scope: GlobalScope, Index: 2}); got != want {
+		t.Errorf("expected c=%+v, got=%+v", want, got)
+	}
+	if got, want := mustResolve(t, module, "len"), (Symbol{Name: "len", Scope: BuiltinScope, Index: 0}); got != want {
+		t.Errorf("expected len=%+v, got=%+v", want, got)
+	}
+}
+
+func TestBlockSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	block := NewBlockSymbolTable(global)
+	if got, want := block.Define("a"), (Symbol{Name: "a", Scope: GlobalScope, Index: 1}); got != want {
+		t.Errorf("expected a=%+v, got=%+v", want, got)
+	}
+	if got, want := mustResolve(t, global, "a"), (Symbol{Name: "a", Scope: GlobalScope, Index: 0}); got != want {
+		t.Errorf("expected a=%+v, got=%+v", want, got)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+	block = NewBlockSymbolTable(local)
+	if got, want := block.Define("c"), (Symbol{Name: "c", Scope: LocalScope, Index: 1}); got != want {
+		t.Errorf("expected c=%+v, got=%+v", want, got)
+	}
+	if got, want := mustResolve(t, block, "b"), (Symbol{Name: "b", Scope: LocalScope, Index: 0}); got != want {
+		t.Errorf("expected b=%+v, got=%+v", want, got)
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("name c should not resolve outside the block")
+	}
+	if local.numDefinitions != 2 {
+		t.Errorf("expected 2 local definitions, got=%d", local.numDefinitions)
+	}
+
+	nested := NewEnclosedSymbolTable(block)
+	if got, want := mustResolve(t, nested, "c"), (Symbol{Name: "c", Scope: FreeScope, Index: 0}); got != want {
+		t.Errorf("expected c=%+v, got=%+v", want, got)
+	}
+	if len(block.FreeSymbols) != 0 {
+		t.Errorf("block should have no free symbols, got=%+v", block.FreeSymbols)
+	}
+}
+
+func mustResolve(t *testing.T, s *SymbolTable, name string) Symbol {
+	t.Helper()
+	symbol, ok := s.Resolve(name)
+	if !ok {
+		t.Fatalf("name %s not resolvable", name)
+	}
+	return symbol
+}
@@ -1,29 +1,48 @@
 package evaluator
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/lqqyt2423/go-monkey/ast"
 	"github.com/lqqyt2423/go-monkey/object"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// maxCallDepth limits how deeply function calls nest, as MaxFrames does in
+// the VM.
+const maxCallDepth = 1024
+
 var builtins = map[string]*object.Builtin{
-	"len": object.GetBuiltinByName("len"),
+	"len":   object.GetBuiltinByName("len"),
+	"puts":  object.GetBuiltinByName("puts"),
+	"int":   object.GetBuiltinByName("int"),
+	"float": object.GetBuiltinByName("float"),
 }
 
+// Eval evaluates node, giving an error raised by it the node's position
+// unless a node inside it already claimed the error.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := evalNode(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
 	case *ast.ReturnStatement:
 		v := Eval(node.ReturnValue, env)
-		if isError(v) {
+		if isAbrupt(v) {
 			return v
 		}
 		return &object.ReturnValue{Value: v}
@@ -31,17 +50,46 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
 		return evalStatements(node.Statements, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return object.NewThrow(val)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInteger{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		var out strings.Builder
+		for _, part := range node.Parts {
+			val := Eval(part, env)
+			if isAbrupt(val) {
+				return val
+			}
+			out.WriteString(object.Display(val))
+		}
+		return &object.String{Value: out.String()}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.ArrayLiteral:
 		var elements []object.Object
 		for _, ele := range node.Elements {
 			element := Eval(ele, env)
-			if isError(element) {
+			if isAbrupt(element) {
 				return element
 			}
 			elements = append(elements, element)
@@ -49,49 +97,78 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Array{Elements: elements}
 	case *ast.HashLiteral:
 		hash := &object.Hash{
-			Pairs: make(map[string]object.Object),
+			Pairs: make(map[object.HashKey]object.HashPair),
 		}
 		for k, v := range node.Pairs {
 			key := Eval(k, env)
-			if isError(key) {
+			if isAbrupt(key) {
 				return key
 			}
-			keyStr, ok := key.(*object.String)
+			hashKey, ok := key.(object.Hashable)
 			if !ok {
-				return newError("hash key should be String, but got %s", key.Type())
+				return newError(object.TypeError, "unusable as hash key: %s", key.Type())
 			}
 			val := Eval(v, env)
-			if isError(val) {
+			if isAbrupt(val) {
 				return val
 			}
-			hash.Pairs[keyStr.Value] = val
+			hash.Pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: val}
 		}
 		return hash
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
+			return left
+		}
+		// && and || yield the operand that decided the result and only
+		// evaluate the right side when it is needed
+		if node.Operator == "&&" && !isTruthy(left) || node.Operator == "||" && isTruthy(left) {
 			return left
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return Eval(node.Right, env)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.LetStatement:
 		value := Eval(node.Value, env)
-		if isError(value) {
+		if isAbrupt(value) {
+			return value
+		}
+		if node.Pattern != nil {
+			if err := bindPattern(node.Pattern, value, env); err != nil {
+				return err
+			}
+			return NULL
+		}
+		env.Set(node.Name.Value, value)
+		return NULL
+	case *ast.ImportStatement:
+		value := Eval(node.Import, env)
+		if isAbrupt(value) {
 			return value
 		}
 		env.Set(node.Name.Value, value)
 		return NULL
+	case *ast.ImportExpression:
+		return evalImportExpression(node, env)
 	case *ast.Identifier:
 		val, ok := env.Get(node.Value)
 		if ok {
@@ -101,17 +178,29 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if ok {
 			return val
 		}
-		return newError("identifier not found: %s", node.Value)
+		return newError(object.NameError, "identifier not found: %s", node.Value)
 	case *ast.FunctionLiteral:
 		return &object.Function{
 			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Patterns:   node.Patterns,
+			Rest:       node.Rest,
 			Body:       node.Body,
 			Env:        env,
 		}
 	case *ast.CallExpression:
+		if isCallTo(node, "quote") {
+			if len(node.Arguments) != 1 {
+				return object.NewArityError(1, len(node.Arguments))
+			}
+			return quote(node.Arguments[0], env)
+		}
 		return evalCallExpression(node, env)
 	case *ast.IndexExpression:
 		return evalIndexExpression(node, env)
+	case *ast.MacroLiteral:
+		// DefineMacros takes the ones in top-level let statements out
+		return newError(object.TypeError, "macro literal only allowed in top-level let")
 	default:
 		return NULL
 	}
@@ -131,19 +220,77 @@ func evalStatements(stmts []ast.Statement, env *object.Environment) object.Objec
 	var result object.Object
 	for _, stmt := range stmts {
 		result = Eval(stmt, env)
-		if result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
+		if isAbrupt(result) {
 			return result
 		}
 	}
 	return result
 }
 
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		if result, stop := evalLoopBody(node.Body, env); stop {
+			return result
+		}
+	}
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	it, ok := iterable.(object.Iterable)
+	if !ok {
+		return newError(object.TypeError, "not iterable: %s", iterable.Type())
+	}
+	iter := it.Iterator()
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			return NULL
+		}
+		if node.Key != nil {
+			env.Set(node.Key.Value, key)
+		}
+		env.Set(node.Value.Value, value)
+		if result, stop := evalLoopBody(node.Body, env); stop {
+			return result
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop body and reports whether the
+// loop has to stop, along with the value the loop evaluates to if so.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	switch result {
+	case BREAK:
+		return NULL, true
+	case CONTINUE, nil:
+		return nil, false
+	}
+	if result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
+		return result, true
+	}
+	return nil, false
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusOperatorExpression(right)
+	case "~":
+		return evalBitNotOperatorExpression(right)
 	default:
 		return NULL
 	}
@@ -163,11 +310,21 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("type mismatch: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer, *object.BigInteger:
+		return object.NegateInteger(right)
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
+		return newError(object.TypeError, "unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+}
+
+func evalBitNotOperatorExpression(right object.Object) object.Object {
+	if !object.IsInteger(right) {
+		return newError(object.TypeError, "unknown operator: ~%s", right.Type())
+	}
+	return object.BitNotInteger(right)
 }
 
 func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -178,56 +335,89 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 		case "+":
 			return &object.String{Value: leftVal + rightVal}
 		case "==":
-			return &object.Boolean{Value: leftVal == rightVal}
+			return nativeBoolToBooleanObject(leftVal == rightVal)
 		case "!=":
-			return &object.Boolean{Value: leftVal != rightVal}
+			return nativeBoolToBooleanObject(leftVal != rightVal)
+		case "<":
+			return nativeBoolToBooleanObject(leftVal < rightVal)
+		case ">":
+			return nativeBoolToBooleanObject(leftVal > rightVal)
+		case "<=":
+			return nativeBoolToBooleanObject(leftVal <= rightVal)
+		case ">=":
+			return nativeBoolToBooleanObject(leftVal >= rightVal)
+		}
+		return object.NewInfixError(operator, left, right)
+	}
+
+	if left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ {
+		if _, ok := object.FloatValue(left); ok {
+			if _, ok := object.FloatValue(right); ok {
+				return evalFloatInfixExpression(operator, left, right)
+			}
 		}
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	}
 
-	if left.Type() != object.INTEGER_OBJ || right.Type() != object.INTEGER_OBJ {
+	if !object.IsInteger(left) || !object.IsInteger(right) {
 		if operator == "==" || operator == "!=" {
 			return evalEqInfixCompress(operator, left, right)
 		}
-		return newError("type mismatch: %s + %s", left.Type(), right.Type())
+		return object.NewInfixError(operator, left, right)
 	}
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	return evalIntegerInfixExpression(operator, left, right)
+}
+
+func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
+	}
+	result, err := object.IntegerOperation(operator, left, right)
+	if err != nil {
+		return err
+	}
+	return result
+}
+
+func evalFloatInfixExpression(operator string, leftObj, rightObj object.Object) object.Object {
+	left, _ := object.FloatValue(leftObj)
+	right, _ := object.FloatValue(rightObj)
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftValue + rightValue}
+		return &object.Float{Value: left + right}
 	case "-":
-		return &object.Integer{Value: leftValue - rightValue}
+		return &object.Float{Value: left - right}
 	case "*":
-		return &object.Integer{Value: leftValue * rightValue}
+		return &object.Float{Value: left * right}
 	case "/":
-		return &object.Integer{Value: leftValue / rightValue}
-	case "<":
-		if leftValue < rightValue {
-			return TRUE
-		} else {
-			return FALSE
+		if right == 0 {
+			return newError(object.ZeroDivisionError, "division by zero")
 		}
+		return &object.Float{Value: left / right}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
 	case ">":
-		if leftValue > rightValue {
-			return TRUE
-		} else {
-			return FALSE
-		}
+		return nativeBoolToBooleanObject(left > right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
 	case "==":
-		if leftValue == rightValue {
-			return TRUE
-		} else {
-			return FALSE
-		}
+		return nativeBoolToBooleanObject(left == right)
 	case "!=":
-		if leftValue != rightValue {
-			return TRUE
-		} else {
-			return FALSE
-		}
+		return nativeBoolToBooleanObject(left != right)
 	default:
-		return NULL
+		return object.NewInfixError(operator, leftObj, rightObj)
 	}
 }
 
@@ -244,7 +434,7 @@ func evalEqInfixCompress(operator string, left object.Object, right object.Objec
 
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(node.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
@@ -256,73 +446,326 @@ func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Ob
 	return NULL
 }
 
+// evalTryExpression runs the finally block however the try and catch
+// blocks end; only an error, return or loop jump out of the finally block
+// itself replaces their result.
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		if node.CatchParam != nil {
+			env.Set(node.CatchParam.Value, err.CatchValue())
+		}
+		result = Eval(node.Catch, env)
+	}
+	if node.Finally != nil {
+		finally := Eval(node.Finally, env)
+		if isAbrupt(finally) {
+			return finally
+		}
+	}
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
 func evalCallExpression(node *ast.CallExpression, env *object.Environment) object.Object {
 	function := Eval(node.Function, env)
-	if isError(function) {
+	if isAbrupt(function) {
 		return function
 	}
 
 	var args []object.Object
 	for _, argument := range node.Arguments {
 		arg := Eval(argument, env)
-		if isError(arg) {
+		if isAbrupt(arg) {
 			return arg
 		}
 		args = append(args, arg)
 	}
+	var keywords []string
+	for _, kw := range node.Keywords {
+		arg := Eval(kw.Value, env)
+		if isAbrupt(arg) {
+			return arg
+		}
+		args = append(args, arg)
+		keywords = append(keywords, kw.Name.Value)
+	}
 
 	switch funcObj := function.(type) {
 	case *object.Builtin:
-		return funcObj.Fn(args...)
-	case *object.Function:
-		if len(funcObj.Parameters) != len(args) {
-			return newError("arguments len %d mismatch, want %d", len(args), len(funcObj.Parameters))
+		if len(keywords) > 0 {
+			return newError(object.ArityError, "unknown keyword argument: %s", keywords[0])
 		}
-
-		callEnv := object.ExtendEnvironment(funcObj.Parameters, args, funcObj.Env)
-		val := Eval(funcObj.Body, callEnv)
-		if rval, ok := val.(*object.ReturnValue); ok {
-			return rval.Value
+		if result := funcObj.Fn(args...); result != nil {
+			return result
 		}
-		return val
+		return NULL
+	case *object.Function:
+		return applyFunction(funcObj, args, keywords, env)
 	default:
-		return newError("type mismatch: %s()", function.Type())
+		return newError(object.TypeError, "not a function: %s", function.Type())
+	}
+}
+
+// applyFunction calls fn from env with the positional arguments in args
+// followed by the keyword arguments named by keywords.
+func applyFunction(fn *object.Function, args []object.Object, keywords []string, env *object.Environment) object.Object {
+	if env.Depth() >= maxCallDepth {
+		return newError(object.StackOverflowError, "stack overflow")
+	}
+	bound, passed, err := fn.Signature().Bind(args, keywords)
+	if err != nil {
+		return err
+	}
+
+	// Defaults are evaluated in the callee's scope, where the parameters
+	// before them are already bound.
+	callEnv := object.NewCallEnvironment(fn.Env, env)
+	for i, param := range fn.Parameters {
+		arg := bound[i]
+		if !passed[i] {
+			arg = Eval(fn.Defaults[i], callEnv)
+			if rval, ok := arg.(*object.ReturnValue); ok {
+				// a return in a default returns from the function
+				return rval.Value
+			}
+			if isAbrupt(arg) {
+				return arg
+			}
+		}
+		callEnv.Set(param.Value, arg)
+		if i < len(fn.Patterns) && fn.Patterns[i] != nil {
+			if err := bindPattern(fn.Patterns[i], arg, callEnv); err != nil {
+				return err
+			}
+		}
 	}
+	if fn.Rest != nil {
+		callEnv.Set(fn.Rest.Value, bound[len(fn.Parameters)])
+	}
+	val := Eval(fn.Body, callEnv)
+	if rval, ok := val.(*object.ReturnValue); ok {
+		return rval.Value
+	}
+	return val
 }
 
 func evalIndexExpression(node *ast.IndexExpression, env *object.Environment) object.Object {
 	left := Eval(node.Left, env)
-	if isError(left) {
+	if isAbrupt(left) {
 		return left
 	}
 	index := Eval(node.Index, env)
-	if isError(index) {
+	if isAbrupt(index) {
 		return index
 	}
 
 	switch leftObj := left.(type) {
 	case *object.Array:
-		indexVal, ok := index.(*object.Integer)
-		if !ok {
-			return newError("type mismatch: %s", index.Type())
+		i, err := leftObj.Index(index)
+		if err != nil {
+			return err
 		}
-		if indexVal.Value < 0 || indexVal.Value >= int64(len(leftObj.Elements)) {
-			return newError("out of index")
-		}
-		return leftObj.Elements[indexVal.Value]
+		return leftObj.Elements[i]
 	case *object.Hash:
-		indexVal, ok := index.(*object.String)
+		key, ok := index.(object.Hashable)
 		if !ok {
-			return newError("type mismatch: %s", index.Type())
+			return newError(object.TypeError, "unusable as hash key: %s", index.Type())
 		}
-		val, ok := leftObj.Pairs[indexVal.Value]
+		pair, ok := leftObj.Pairs[key.HashKey()]
 		if ok {
-			return val
+			return pair.Value
 		} else {
 			return NULL
 		}
+	case *object.Module:
+		value, err := leftObj.Export(index)
+		if err != nil {
+			return err
+		}
+		return value
 	default:
-		return newError("type mismatch: %s", left.Type())
+		return newError(object.TypeError, "index operator not supported: %s", left.Type())
+	}
+}
+
+// evalImportExpression runs the imported file in an environment of its own
+// the first time it is imported and collects its exports.
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	file := node.File()
+	return env.Modules().Load(file, func() object.Object {
+		program, err := ParseModule(file)
+		if err != nil {
+			return newError(object.ImportError, "%s", err)
+		}
+		moduleEnv := object.NewModuleEnvironment(env)
+		result := Eval(program, moduleEnv)
+		if isError(result) {
+			return result
+		}
+		exports := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		for _, stmt := range program.Statements {
+			let, ok := stmt.(*ast.LetStatement)
+			if !ok || !let.Exported {
+				continue
+			}
+			for _, ident := range let.Names() {
+				name := &object.String{Value: ident.Value}
+				value, _ := moduleEnv.Get(ident.Value)
+				exports.Pairs[name.HashKey()] = object.HashPair{Key: name, Value: value}
+			}
+		}
+		return &object.Module{Name: file, Exports: exports}
+	})
+}
+
+// bindPattern binds the names of pattern to the parts of value in env.
+func bindPattern(pattern ast.Pattern, value object.Object, env *object.Environment) *object.Error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.Identifier:
+		env.Set(pattern.Value, value)
+		return nil
+	}
+	parts, targets, err := destructure(pattern, value)
+	if err != nil {
+		err.Pos = pattern.Pos()
+		return err
+	}
+	for i, target := range targets {
+		if err := bindPattern(target, parts[i], env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evalMatchExpression evaluates the body of the first arm whose pattern
+// matches and whose guard holds. The names the patterns bind are set in
+// env as they match.
+func evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(node.Subject, env)
+	if isAbrupt(subject) {
+		return subject
+	}
+	for _, arm := range node.Arms {
+		// each arm binds its names in an environment of its own
+		env := object.NewEnclosedEnvironment(env)
+		if !matchPattern(arm.Pattern, subject, env) {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, env)
+			if isAbrupt(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		result := Eval(arm.Body, env)
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+	return newError(object.MatchError, "no match for %s", subject.Inspect())
+}
+
+func matchPattern(pattern ast.Pattern, value object.Object, env *object.Environment) bool {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.Identifier:
+		env.Set(pattern.Value, value)
+		return true
+	case *ast.LiteralPattern:
+		return evalInfixExpression("==", value, Eval(pattern.Value, env)) == TRUE
+	}
+	parts, targets, err := destructure(pattern, value)
+	if err != nil {
+		return false
+	}
+	for i, target := range targets {
+		if !matchPattern(target, parts[i], env) {
+			return false
+		}
+	}
+	return true
+}
+
+// destructure splits value into the parts an array or hash pattern binds,
+// and returns them along with their patterns.
+func destructure(pattern ast.Pattern, value object.Object) ([]object.Object, []ast.Pattern, *object.Error) {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		parts, err := object.DestructureArray(value, len(pattern.Elements), pattern.Rest != nil)
+		targets := pattern.Elements
+		if pattern.Rest != nil {
+			targets = append(targets[:len(targets):len(targets)], pattern.Rest)
+		}
+		return parts, targets, err
+	case *ast.HashPattern:
+		parts, err := object.DestructureHash(value, pattern.KeyNames())
+		return parts, pattern.Targets, err
+	}
+	return nil, nil, nil
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if !env.Assign(target.Value, val) {
+			// reported at the name, as the compiler does
+			err := object.NewError(object.NameError, "identifier not found: %s", target.Value)
+			err.Pos = target.Pos()
+			return err
+		}
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val)
+	default:
+		return newError(object.TypeError, "invalid assignment target: %s", node.Target.String())
+	}
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch leftObj := left.(type) {
+	case *object.Array:
+		i, err := leftObj.Index(index)
+		if err != nil {
+			return err
+		}
+		leftObj.Elements[i] = val
+		return val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(object.TypeError, "unusable as hash key: %s", index.Type())
+		}
+		leftObj.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return val
+	default:
+		return newError(object.TypeError, "index operator not supported: %s", left.Type())
 	}
 }
 
@@ -344,13 +787,21 @@ func isTruthy(v object.Object) bool {
 	}
 }
 
-func newError(format string, a ...any) object.Object {
-	return &object.Error{
-		Message: fmt.Sprintf(format, a...),
-	}
+func newError(kind object.ErrorKind, format string, a ...any) object.Object {
+	return object.NewError(kind, format, a...)
 }
 
 func isError(v object.Object) bool {
 	_, ok := v.(*object.Error)
 	return ok
 }
+
+// isAbrupt reports whether v ends the evaluation of the expressions and
+// statements around it, as an error, a return or a loop jump does.
+func isAbrupt(v object.Object) bool {
+	switch v.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	}
+	return false
+}
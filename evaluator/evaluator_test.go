@@ -23,6 +23,9 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"len([1])", 1},
 		{"len({})", 0},
 		{`len({"name":"lq"})`, 1},
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{`int("42")`, 42},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -52,6 +55,18 @@ func TestEvalStringExpression(t *testing.T) {
 		{`"world"`, "world"},
 		{`"hello" + " world"`, "hello world"},
 		{`{"name":"lq"}["name"]`, "lq"},
+		{`{1: "one", true: "yes"}[1]`, "one"},
+		{`{1: "one", true: "yes"}[true]`, "yes"},
+		{`let key = "na"; {"name": "lq"}[key + "me"]`, "lq"},
+		{`"line\n" + "\ttab"`, "line\n\ttab"},
+		{`"say \"hi\""`, `say "hi"`},
+		{"`raw\n\\n`", "raw\n\\n"},
+		{`let name = "lq"; "hello ${name}!"`, "hello lq!"},
+		{`let items = [1, 2]; "you have ${len(items)} items: ${items}"`, "you have 2 items: [1, 2]"},
+		{`"${1 + 1.5} ${true} ${if (false) { 1 }} ${"s"}"`, "2.5 true null s"},
+		{`"${puts()}"`, "null"},
+		{`let x = 3; "${ "<${x * 2}>" }"`, "<6>"},
+		{`"no ${"nested ${"deep"}"} end"`, "no nested deep end"},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -147,6 +162,21 @@ func TestEvalIntegerInfixExpression(t *testing.T) {
 		{"3*4+5", 17},
 		{"4/2-2", 0},
 		{"5/2+5*2", 12},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 4", 16},
+		{"-16 >> 2", -4},
+		{"~5", -6},
+		{"~-1", 0},
+		{"1 << 62 >> 60", 4},
+		{"1 + 6 & 3", 3},
+		{"1 | 2 * 4", 9},
+		{"5 ^ 1 + 1", 5},
+		{"(17 * 31 + 5) % 8", 4},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -190,8 +220,30 @@ func TestEvalCompareInfixExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1<=2", true},
+		{"2<=2", true},
+		{"3<=2", false},
+		{"1>=2", false},
+		{"2>=2", true},
+		{"3>=2", true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"a" > "b"`, false},
+		{`"abc" > "abd"`, false},
+		{`"ab" < "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"b" >= "a"`, true},
+		{`"" < "a"`, true},
+		{"1.5 < 2", true},
+		{"2.0 >= 2", true},
+		{"1.9 <= 1", false},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
 		{`"hello" == "hello"`, true},
+		{`"hello" == "world"`, false},
 		{`"hello" != "hello"`, false},
+		{`"hello" != "world"`, true},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -262,6 +314,8 @@ func TestReturnStatement(t *testing.T) {
 		{"return 2 * 5; 9;", 10},
 		{"9; return 2 * 5; 9;", 10},
 		{`if (10 > 1) { if (10 > 1) { return 10; } return 1;}`, 10},
+		{"let f = fn() { 1 + if (true) { return 5; } }; f()", 5},
+		{"let f = fn(x = if (true) { return 7; }) { x + 1 }; f()", 7},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -285,31 +339,143 @@ func TestReturnStatement(t *testing.T) {
 func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input     string
+		wantKind  object.ErrorKind
 		wantValue string
 	}{
 		{
 			"5 + true;",
+			object.TypeError,
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"puts() + 1",
+			object.TypeError,
+			"type mismatch: NULL + INTEGER",
+		},
+		{
+			`9223372036854775808 + "a"`,
+			object.TypeError,
+			"type mismatch: INTEGER + STRING",
+		},
+		{
+			"[1][1 << 64]",
+			object.IndexError,
+			"index out of range: 18446744073709551616",
+		},
 		{
 			"5 + true; 5;",
+			object.TypeError,
 			"type mismatch: INTEGER + BOOLEAN",
 		},
 		{
 			"-true",
-			"type mismatch: -BOOLEAN",
+			object.TypeError,
+			"unknown operator: -BOOLEAN",
+		},
+		{
+			"fn(a, b) { a }(1)",
+			object.ArityError,
+			"missing argument for parameter b",
+		},
+		{
+			"fn(a, ...rest) { a }()",
+			object.ArityError,
+			"missing argument for parameter a",
+		},
+		{
+			"fn(a, b, c = 1) { a }(c: 2)",
+			object.ArityError,
+			"missing arguments for parameters a, b",
+		},
+		{
+			"fn(a) { a }(1, 2)",
+			object.ArityError,
+			"wrong number of arguments: want=1, got=2",
+		},
+		{
+			"fn(a) { a }(b: 1)",
+			object.ArityError,
+			"unknown keyword argument: b",
+		},
+		{
+			"fn(a) { a }(1, a: 2)",
+			object.ArityError,
+			"multiple values for parameter a",
+		},
+		{
+			`len("x", y: 1)`,
+			object.ArityError,
+			"unknown keyword argument: y",
+		},
+		{
+			"fn(a = b) { a }()",
+			object.NameError,
+			"identifier not found: b",
+		},
+		{
+			"let [a, b] = 5",
+			object.TypeError,
+			"cannot destructure INTEGER as an array",
+		},
+		{
+			"let [a, b] = [1, 2, 3]",
+			object.ValueError,
+			"cannot destructure array of length 3 into 2 elements",
+		},
+		{
+			"let [a, b, ...c] = [1]",
+			object.ValueError,
+			"cannot destructure array of length 1 into at least 2 elements",
+		},
+		{
+			"let [a] = []",
+			object.ValueError,
+			"cannot destructure array of length 0 into 1 element",
+		},
+		{
+			"let [a, ...b] = []",
+			object.ValueError,
+			"cannot destructure array of length 0 into at least 1 element",
+		},
+		{
+			`let {a} = [1]`,
+			object.TypeError,
+			"cannot destructure ARRAY as a hash",
+		},
+		{
+			`let {name, age} = {"name": "ann"}`,
+			object.ValueError,
+			`cannot destructure hash without key "age"`,
+		},
+		{
+			"fn([a]) { a }(1)",
+			object.TypeError,
+			"cannot destructure INTEGER as an array",
+		},
+		{
+			"match (5) { 1 => 1 }",
+			object.MatchError,
+			"no match for 5",
+		},
+		{
+			`match ([1, "a"]) { [a, b] if b > 0 => 1 }`,
+			object.TypeError,
+			"type mismatch: STRING > INTEGER",
 		},
 		{
 			"true + false;",
-			"type mismatch: BOOLEAN + BOOLEAN",
+			object.TypeError,
+			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{
 			"5; true + false; 5",
-			"type mismatch: BOOLEAN + BOOLEAN",
+			object.TypeError,
+			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{
 			"if (10 > 1) { true + false; }",
-			"type mismatch: BOOLEAN + BOOLEAN",
+			object.TypeError,
+			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{
 			`
@@ -321,19 +487,113 @@ if (10 > 1) {
   return 1;
 }
 `,
-			"type mismatch: BOOLEAN + BOOLEAN",
+			object.TypeError,
+			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{
 			"foobar",
+			object.NameError,
 			"identifier not found: foobar",
 		},
 		{
 			`"hello" - "hello"`,
-			"type mismatch: STRING - STRING",
+			object.TypeError,
+			"unknown operator: STRING - STRING",
 		},
 		{
 			"[1][1]",
-			"out of index",
+			object.IndexError,
+			"index out of range: 1",
+		},
+		{
+			`{"name": "Monkey"}[fn(x) { x }];`,
+			object.TypeError,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`{[1]: 2}`,
+			object.TypeError,
+			"unusable as hash key: ARRAY",
+		},
+		{
+			"for (x in 5) { x }",
+			object.TypeError,
+			"not iterable: INTEGER",
+		},
+		{
+			"x = 1",
+			object.NameError,
+			"identifier not found: x",
+		},
+		{
+			"1 / 0",
+			object.ZeroDivisionError,
+			"division by zero",
+		},
+		{
+			"let x = 0; 10 % x",
+			object.ZeroDivisionError,
+			"division by zero",
+		},
+		{
+			"1 << -1",
+			object.ValueError,
+			"negative shift count: -1",
+		},
+		{
+			"~true",
+			object.TypeError,
+			"unknown operator: ~BOOLEAN",
+		},
+		{
+			"1.5 / 0",
+			object.ZeroDivisionError,
+			"division by zero",
+		},
+		{
+			"1.5 % 2",
+			object.TypeError,
+			"unknown operator: FLOAT % INTEGER",
+		},
+		{
+			`"a ${x} b"`,
+			object.NameError,
+			"identifier not found: x",
+		},
+		{
+			`int("4x")`,
+			object.ValueError,
+			`could not parse "4x" as integer`,
+		},
+		{
+			"let f = fn() { y = 1 }; f();",
+			object.NameError,
+			"identifier not found: y",
+		},
+		{
+			"let a = [1]; a[1] = 2;",
+			object.IndexError,
+			"index out of range: 1",
+		},
+		{
+			"let h = {}; h[[]] = 1;",
+			object.TypeError,
+			"unusable as hash key: ARRAY",
+		},
+		{
+			"let f = fn() { macro(x) { x } }; f()",
+			object.TypeError,
+			"macro literal only allowed in top-level let",
+		},
+		{
+			"let f = fn() { f() }; f()",
+			object.StackOverflowError,
+			"stack overflow",
+		},
+		{
+			"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100000)",
+			object.StackOverflowError,
+			"stack overflow",
 		},
 	}
 	for _, tt := range tests {
@@ -348,6 +608,9 @@ if (10 > 1) {
 			if !ok {
 				t.Fatalf("should be *object.Error, but got %T", obj)
 			}
+			if iobj.Kind != tt.wantKind {
+				t.Fatalf("kind want %s, but got %s", tt.wantKind, iobj.Kind)
+			}
 			if iobj.Message != tt.wantValue {
 				t.Fatalf("value want %q, but got %q", tt.wantValue, iobj.Message)
 			}
@@ -414,6 +677,12 @@ func TestFunctionApplication(t *testing.T) {
 		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
 		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
 		{"fn(x) { x; }(5)", 5},
+		{"let count = fn(...xs) { len(xs) }; count() + count(1, 2, 3);", 3},
+		{"let f = fn(a, ...rest) { a + len(rest) }; f(10) + f(10, 20, 30);", 22},
+		{"let sum = fn(...xs) { let s = 0; for (x in xs) { s = s + x }; s }; sum(1, 2, 3, 4);", 10},
+		{"let last = fn(a, b, ...rest) { rest[len(rest) - 1] }; last(1, 2, 3, 4);", 4},
+		{"let outer = fn(x) { fn(...ys) { x + len(ys) } }; outer(5)(1, 1);", 7},
+		{"let f = fn(...xs) { xs[0] = 9; xs[0] }; f(1);", 9},
 		{
 			`
 let newAdder = fn(x) {
@@ -443,3 +712,713 @@ addTwo(2);`,
 		})
 	}
 }
+
+func TestDefaultsAndKeywordArguments(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue interface{}
+	}{
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open("a")`, "a:r"},
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open("a", "w")`, "a:w"},
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open("a", mode: "w")`, "a:w"},
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open(mode: "w", path: "b")`, "b:w"},
+		{"let f = fn(a, b = a * 2) { a + b }; f(3)", 9},
+		{"let f = fn(a = 1, b = 2, c = 3) { a * 100 + b * 10 + c }; f(b: 5)", 153},
+		{"let n = 0; let f = fn(x = n) { x }; n = 7; f()", 7},
+		{"let f = fn(a, b = 10, ...rest) { a + b + len(rest) }; f(1) + f(1, 2, 3, 4)", 16},
+		{"let f = fn(x = if (true) { 5 }) { x }; f()", 5},
+		{"let f = fn(a) { a }; f(a: 4)", 4},
+		{"let f = fn(a = 5) { a }; f(if (false) { 1 })", nil},
+		{"let f = fn(b, a = 5) { a }; f(1, a: if (false) { 1 })", nil},
+		{"let f = fn(x) { x }; f(puts())", nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			switch want := tt.wantValue.(type) {
+			case int:
+				iobj, ok := obj.(*object.Integer)
+				if !ok || iobj.Value != int64(want) {
+					t.Fatalf("value want %d, but got %+v", want, obj)
+				}
+			case string:
+				sobj, ok := obj.(*object.String)
+				if !ok || sobj.Value != want {
+					t.Fatalf("value want %q, but got %+v", want, obj)
+				}
+			case nil:
+				if obj != NULL {
+					t.Fatalf("value want null, but got %+v", obj)
+				}
+			}
+		})
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [first, second, ...tail] = [1, 2, 3, 4]; first + second + len(tail) * 100", 203},
+		{"let [x, ...rest] = [1]; len(rest)", 0},
+		{`let {name, age} = {"name": "ann", "age": 30}; "${name}:${age}"`, "ann:30"},
+		{`let {name: n, tags: [t, ...more]} = {"name": "x", "tags": ["a", "b"]}; n + t + more[0]`, "xab"},
+		{`let [{id}, [z]] = [{"id": 7}, [8]]; id + z`, 15},
+		{"let a = 1; let b = 2; let [a, b] = [b, a]; a * 10 + b", 21},
+		{"let f = fn([a, b], {c}) { a + b + c }; f([1, 2], {\"c\": 3})", 6},
+		{"let f = fn(x, [a, b] = [x, x * 2]) { a + b }; f(5)", 15},
+		{"let f = fn() { let [a, b] = [1, 2]; fn() { a + b } }; f()()", 3},
+		{"let pairs = [[1, 2], [3, 4]]; let sum = 0; for (p in pairs) { let [a, b] = p; sum = sum + a * b }; sum", 14},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			switch want := tt.wantValue.(type) {
+			case int:
+				iobj, ok := obj.(*object.Integer)
+				if !ok || iobj.Value != int64(want) {
+					t.Fatalf("value want %d, but got %+v", want, obj)
+				}
+			case string:
+				sobj, ok := obj.(*object.String)
+				if !ok || sobj.Value != want {
+					t.Fatalf("value want %q, but got %+v", want, obj)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue interface{}
+	}{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (2) { 1 => "one", _ => "other" }`, "other"},
+		{"match (-1) { 1 => 0, -1 => 10 }", 10},
+		{"match (1.5) { 1 => 0, 1.5 => 1 }", 1},
+		{`match ("1") { 1 => "int", "1" => "string" }`, "string"},
+		{"match (true) { false => 1, true => 2 }", 2},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b }", 3},
+		{"match ([1, 2, 3]) { [2, ...rest] => 0, [1, ...rest] => len(rest) }", 2},
+		{"match ([1]) { [_, _] => 1, [_] => 2 }", 2},
+		{`match ({"type": "x", "data": 5}) { {"type": "y", data} => 0, {"type": "x", data} => data }`, 5},
+		{`match ({"a": 1}) { {b} => b, {a} => a }`, 1},
+		{`match ([[1, 2], {"k": 3}]) { [[a, b], {k}] => a + b + k }`, 6},
+		{"match ([1, 2]) { [x, 3] => 0, [x, y] => x * 10 + y }", 12},
+		{"match (5) { n if n > 10 => 1, n if n > 3 => 2, _ => 3 }", 2},
+		{`match ({"a": [1, 2]}) { {a: [x, y]} if x > y => "desc", {a: [x, y]} => "asc" }`, "asc"},
+		{"match (3) { n => { let d = n * 2; d + 1 } }", 7},
+		{"match (1) { _ => {} }", nil},
+		{"let x = match (2) { 2 => 20 }; x", 20},
+		{"let f = fn(v) { match (v) { [] => 0, [x, ...xs] => x + f(xs) } }; f([1, 2, 3])", 6},
+		{"let n = 0; for (x in [1, 2, 3, 4]) { match (x) { 3 => { break; } _ => { n = n + x } } }; n", 3},
+		{"let f = fn(x) { match (x) { 1 => { return 10; } _ => 0 }; 5 }; f(1) + f(2)", 15},
+		{"let f = fn(x) { match (x) { [a] if match (a) { 1 => true, _ => false } => a, _ => 0 } }; f([1]) + f([2])", 1},
+		{"let x = 1; match ([2]) { [x] if false => 0, _ => x }", 1},
+		{"let x = 1; match (2) { x => x }; x", 1},
+		{"let f = fn() { let x = 1; match ([2]) { [x] if false => 0, _ => x } }; f()", 1},
+		{"let f = fn() { let x = 1; match (2) { x => { let y = x; y } }; x }; f()", 1},
+		{"let x = 1; match (2) { n => { x = n } }; x", 2},
+		{"let g = match (3) { n => fn() { n } }; g()", 3},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			switch want := tt.wantValue.(type) {
+			case int:
+				iobj, ok := obj.(*object.Integer)
+				if !ok || iobj.Value != int64(want) {
+					t.Fatalf("value want %d, but got %+v", want, obj)
+				}
+			case string:
+				sobj, ok := obj.(*object.String)
+				if !ok || sobj.Value != want {
+					t.Fatalf("value want %q, but got %+v", want, obj)
+				}
+			case nil:
+				if obj != NULL {
+					t.Fatalf("value want NULL, but got %+v", obj)
+				}
+			}
+		})
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue int64
+	}{
+		{
+			`
+let fibonacci = fn(x) {
+  if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+fibonacci(15);`,
+			610,
+		},
+		{
+			`
+let wrapper = fn() {
+  let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } };
+  countDown(3);
+};
+wrapper();`,
+			0,
+		},
+		{
+			`
+let parity = fn(x) {
+  let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+  let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+  isOdd(x);
+};
+parity(7);`,
+			1,
+		},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(500)", 500},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			iobj, ok := obj.(*object.Integer)
+			if !ok {
+				t.Fatalf("should be *object.Integer, but got %T", obj)
+			}
+			if iobj.Value != tt.wantValue {
+				t.Fatalf("value want %d, but got %d", tt.wantValue, iobj.Value)
+			}
+		})
+	}
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue int64
+	}{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i;", 10},
+		{"let i = 0; while (false) { let i = i + 1; }; i;", 0},
+		{"let i = 0; while (true) { if (i == 5) { break; } let i = i + 1; }; i;", 5},
+		{
+			`
+let i = 0;
+let sum = 0;
+while (i < 10) {
+  let i = i + 1;
+  if (i > 3) { continue; }
+  let sum = sum + i;
+}
+sum;`,
+			6,
+		},
+		{
+			`
+let i = 0;
+let count = 0;
+while (i < 3) {
+  let j = 0;
+  while (true) {
+    if (j == 4) { break; }
+    let j = j + 1;
+    let count = count + 1;
+  }
+  let i = i + 1;
+}
+count;`,
+			12,
+		},
+		{
+			`
+let find = fn(n) {
+  let i = 0;
+  while (true) {
+    if (i * i > n) { return i; }
+    let i = i + 1;
+  }
+};
+find(50);`,
+			8,
+		},
+		{"let i = 0; while (i < 100000) { let i = i + 1; }; i;", 100000},
+		{"let c = true; let n = 0; while (n < 3) { n = n + 1; 1 + if (c) { continue } }; n", 3},
+		{"let n = 0; while (true) { n = n + 1; -if (n == 3) { break } else { 1 } }; n", 3},
+		{"let i = 0; while (i < 5000) { i = i + 1; [1, 2, if (true) { continue } else { 0 }] }; i", 5000},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			iobj, ok := obj.(*object.Integer)
+			if !ok {
+				t.Fatalf("should be *object.Integer, but got %T", obj)
+			}
+			if iobj.Value != tt.wantValue {
+				t.Fatalf("value want %d, but got %d", tt.wantValue, iobj.Value)
+			}
+		})
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue int64
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum;", 6},
+		{"let sum = 0; for (i, x in [1, 2, 3]) { let sum = sum + i * x; }; sum;", 8},
+		{"let sum = 0; for (x in []) { let sum = sum + 1; }; sum;", 0},
+		{`let n = 0; for (c in "héllo") { let n = n + 1; }; n;`, 5},
+		{`let last = 0; for (i, c in "abc") { let last = i; }; last;`, 2},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2, "c": 3}) { let sum = sum + v; }; sum;`, 6},
+		{`let sum = 0; for (v in {"a": 1, "b": 2}) { let sum = sum + v; }; sum;`, 3},
+		{
+			`
+let sum = 0;
+for (x in [1, 2, 3, 4, 5, 6]) {
+  if (x == 2) { continue; }
+  if (x == 5) { break; }
+  let sum = sum + x;
+}
+sum;`,
+			8,
+		},
+		{
+			`
+let count = 0;
+for (x in [1, 2, 3]) {
+  for (y in [1, 2]) {
+    if (y == 2) { break; }
+    let count = count + x;
+  }
+}
+count;`,
+			6,
+		},
+		{
+			`
+let first = fn(arr) {
+  for (x in arr) {
+    if (x > 2) { return x; }
+  }
+  0;
+};
+first([1, 5, 3]) + first([1]);`,
+			5,
+		},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + match (x) { 2 => { continue }, _ => x } }; s", 4},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { break } else { x } }; s", 1},
+		{`let n = 0; for (x in [1, 2, 3]) { try { [1, if (x == 2) { throw "e"; } else { 0 }] } catch { 0 }; n = n + [x, if (x == 3) { continue } else { x }][1] }; n`, 3},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			iobj, ok := obj.(*object.Integer)
+			if !ok {
+				t.Fatalf("should be *object.Integer, but got %T (%+v)", obj, obj)
+			}
+			if iobj.Value != tt.wantValue {
+				t.Fatalf("value want %d, but got %d", tt.wantValue, iobj.Value)
+			}
+		})
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue int64
+	}{
+		{"let x = 1; x = 2; x;", 2},
+		{"let x = 1; x = x + 1;", 2},
+		{"let x = 1; let y = 2; x = y = 3; x + y;", 6},
+		{"let x = 1; let f = fn() { x = 5; }; f(); x;", 5},
+		{"let f = fn() { let x = 1; x = x * 10; x }; f();", 10},
+		{
+			`
+let counter = fn() {
+  let n = 0;
+  fn() { n = n + 1; n }
+};
+let c = counter();
+c(); c();
+c();`,
+			3,
+		},
+		{"let i = 0; while (i < 5) { i = i + 1; }; i;", 5},
+		{"let a = [1, 2, 3]; a[1] = 5; a[0] + a[1] + a[2];", 9},
+		{"let a = [1, 2]; let b = a; b[0] = 7; a[0];", 7},
+		{`let h = {"a": 1}; h["a"] = 2; h["b"] = 3; h["a"] + h["b"];`, 5},
+		{`let h = {"a": [1, 2]}; h["a"][1] = 8; h["a"][1];`, 8},
+		{"let a = [0]; a[0] = 4;", 4},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			iobj, ok := obj.(*object.Integer)
+			if !ok {
+				t.Fatalf("should be *object.Integer, but got %T (%+v)", obj, obj)
+			}
+			if iobj.Value != tt.wantValue {
+				t.Fatalf("value want %d, but got %d", tt.wantValue, iobj.Value)
+			}
+		})
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"if (false) { 1 } || 3", 3},
+		{"if (false) { 1 } && 3", nil},
+		{"1 < 2 && 3 > 2 || false", true},
+		{"false && false || true", true},
+		{`let a = []; len(a) > 0 && a[0] == "x"`, false},
+		{`let a = ["x"]; len(a) > 0 && a[0] == "x"`, true},
+		{"let x = 0; false && (x = 1); x", 0},
+		{"let x = 0; true || (x = 1); x", 0},
+		{"let x = 0; true && (x = 1); x", 1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			switch want := tt.wantValue.(type) {
+			case bool:
+				if obj != nativeBoolToBooleanObject(want) {
+					t.Fatalf("value want %t, but got %+v", want, obj)
+				}
+			case int:
+				iobj, ok := obj.(*object.Integer)
+				if !ok || iobj.Value != int64(want) {
+					t.Fatalf("value want %d, but got %+v", want, obj)
+				}
+			case nil:
+				if obj != NULL {
+					t.Fatalf("value want NULL, but got %+v", obj)
+				}
+			}
+		})
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"5.0 - 7", -2.0},
+		{"2 * 1.5", 3.0},
+		{"7 / 2.0", 3.5},
+		{"float(1) / 4", 0.25},
+		{"let total = 8; let done = 3; done * 100.0 / total", 37.5},
+		{"float(3)", 3},
+		{`float("1e-3")`, 0.001},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			fobj, ok := obj.(*object.Float)
+			if !ok {
+				t.Fatalf("should be *object.Float, but got %T (%+v)", obj, obj)
+			}
+			if fobj.Value != tt.wantValue {
+				t.Fatalf("value want %g, but got %g", tt.wantValue, fobj.Value)
+			}
+		})
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	// stands for the representation of the integers that need a BigInteger,
+	// whose type is INTEGER too
+	const bigInteger object.ObjectType = "BIG_INTEGER"
+	tests := []struct {
+		input    string
+		wantType object.ObjectType
+		wantStr  string
+	}{
+		{"9223372036854775807 + 1", bigInteger, "9223372036854775808"},
+		{"let max = 9223372036854775807; max + 1 - 1", object.INTEGER_OBJ, "9223372036854775807"},
+		{"-9223372036854775807 - 2", bigInteger, "-9223372036854775809"},
+		{"4611686018427387904 * 4", bigInteger, "18446744073709551616"},
+		{"(1 << 64) / (1 << 62)", object.INTEGER_OBJ, "4"},
+		{"(1 << 64) % 7", object.INTEGER_OBJ, "2"},
+		{"~(1 << 64)", bigInteger, "-18446744073709551617"},
+		{"let m = -9223372036854775807 - 1; -m", bigInteger, "9223372036854775808"},
+		{"(1 << 64) > 9223372036854775807", object.BOOLEAN_OBJ, "true"},
+		{"(1 << 64) == (1 << 64)", object.BOOLEAN_OBJ, "true"},
+		{"(1 << 64) + 0.5", object.FLOAT_OBJ, "1.8446744073709552e+19"},
+		{"9223372036854775808", bigInteger, "9223372036854775808"},
+		{"18446744073709551616 - 1", bigInteger, "18446744073709551615"},
+		{"-9223372036854775808", object.INTEGER_OBJ, "-9223372036854775808"},
+		{"{(1 << 64): 5}[1 << 64]", object.INTEGER_OBJ, "5"},
+		{`int("123456789012345678901234567890")`, bigInteger, "123456789012345678901234567890"},
+		{
+			`
+let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+fact(25);`,
+			bigInteger,
+			"15511210043330985984000000",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			gotType := obj.Type()
+			if _, ok := obj.(*object.BigInteger); ok {
+				gotType = bigInteger
+			}
+			if gotType != tt.wantType {
+				t.Fatalf("type want %s, but got %s (%s)", tt.wantType, gotType, obj.Inspect())
+			}
+			if obj.Inspect() != tt.wantStr {
+				t.Fatalf("value want %s, but got %s", tt.wantStr, obj.Inspect())
+			}
+		})
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := `let f = fn(x) {
+  x + true
+};
+let y = 1;
+f(y);`
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	obj := Eval(program, object.NewEnvironment())
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("should be *object.Error, but got %T", obj)
+	}
+	if errObj.Inspect() != "ERROR: 2:5: TypeError: type mismatch: INTEGER + BOOLEAN" {
+		t.Fatalf("error want at 2:5, but got %q", errObj.Inspect())
+	}
+
+	obj = Eval(parser.New(lexer.New("let x = 1;\n  y = x")).ParseProgram(), object.NewEnvironment())
+	if obj.Inspect() != "ERROR: 2:3: NameError: identifier not found: y" {
+		t.Fatalf("error want at 2:3, but got %q", obj.Inspect())
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { try { 1 / 0 } catch (e) { throw e; } } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+		{`try { try { 1 / 0 } catch (e) { e["kind"] = "X"; throw e; } } catch (e) { e["kind"] + ":" + e["message"] }`, "X:division by zero"},
+		{`try { try { 1 / 0 } catch (e) { e["message"] = "m"; throw e; } } catch (e) { e["kind"] + ":" + e["message"] }`, "ZeroDivisionError:m"},
+		{`try { try { 1 / 0 } catch (e) { e["kind"] = 1; throw e; } } catch (e) { e["message"] }`, "division by zero"},
+		{`try { throw "bad"; } catch (e) { e }`, "bad"},
+		{"try { throw 5; } catch { 7 }", 7},
+		{"try { } catch { 7 }", nil},
+		{"let x = 0; try { x = 1 } finally { x = x + 10 }; x", 11},
+		{"let n = 0; let f = fn() { try { return 1; } finally { n = n + 1 } }; f() + f() + n", 4},
+		{`let f = fn(x) { if (x == 0) { throw "zero"; } f(x - 1) }; try { f(3) } catch (e) { e }`, "zero"},
+		{
+			`
+let total = 0;
+for (s in ["1", "x", "3"]) {
+  total = total + try { int(s) } catch (e) { 0 };
+}
+total;`,
+			4,
+		},
+		{"try { try { throw 1; } finally { 2 } } catch (e) { e + 10 }", 11},
+		{`try { try { 1 / 0 } catch (e) { throw e["message"]; } } catch (e) { e }`, "division by zero"},
+		{"let n = 0; while (true) { try { break; } finally { n = n + 1 } }; n", 1},
+		{"let n = 0; let i = 0; while (i < 3) { i = i + 1; try { continue; } finally { n = n + 1 } }; n", 3},
+		{"let n = 0; try { try { throw 1; } catch (e) { throw 2; } finally { n = 5 } } catch (e) { e + n }", 7},
+		{"let g = fn() { let a = 1; let h = fn() { a }; throw h; }; try { g() } catch (h) { h() }", 1},
+		{"let f = fn() { try { throw 1; } catch (e) { return e + 1; } }; f()", 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			env := object.NewEnvironment()
+			obj := Eval(program, env)
+			switch want := tt.wantValue.(type) {
+			case int:
+				iobj, ok := obj.(*object.Integer)
+				if !ok || iobj.Value != int64(want) {
+					t.Fatalf("value want %d, but got %+v", want, obj)
+				}
+			case string:
+				sobj, ok := obj.(*object.String)
+				if !ok || sobj.Value != want {
+					t.Fatalf("value want %q, but got %+v", want, obj)
+				}
+			case nil:
+				if obj != NULL {
+					t.Fatalf("value want NULL, but got %+v", obj)
+				}
+			}
+		})
+	}
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`throw "bad record";`, "ERROR: 1:1: Exception: bad record"},
+		{"try { 1 } finally { 1 / 0 }", "ERROR: 1:23: ZeroDivisionError: division by zero"},
+		{"try { throw 1; } finally { 2 }", "ERROR: 1:7: Exception: 1"},
+		{"try { 1 + true } catch (e) { throw e; }", "ERROR: 1:9: TypeError: type mismatch: INTEGER + BOOLEAN"},
+		{`try { 1 + true } catch (e) { e["kind"] = "Oops"; throw e; }`, "ERROR: 1:9: Oops: type mismatch: INTEGER + BOOLEAN"},
+		{`throw {"b": 1, "a": [2], 10: 3, 9: 4};`, `ERROR: 1:1: Exception: {9:4, 10:3, "a":[2], "b":1}`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			obj := Eval(program, object.NewEnvironment())
+			if obj.Inspect() != tt.want {
+				t.Fatalf("error want %q, but got %q", tt.want, obj.Inspect())
+			}
+		})
+	}
+}
+
+func TestImports(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue interface{}
+	}{
+		{`let s = import("../testdata/lib/strings.mk"); s["shout"](s["greeting"])`, "hello!"},
+		{`import "../testdata/lib/util.mk"; util["twice"]("hi")`, "hi!!"},
+		{`import "../testdata/lib/strings.mk"; let again = import("../testdata/lib/strings.mk"); strings["counter"](); again["counter"]()`, 2},
+		{`import "../testdata/lib/strings.mk"; len(strings)`, 3},
+		{`let f = fn() { import("../testdata/lib/strings.mk") }; f()["greeting"]`, "hello"},
+		{`import "../testdata/lib/strings.mk"; let n = 0; for (k, v in strings) { n = n + 1 }; n`, 3},
+		{`let {shout, greeting} = import("../testdata/lib/strings.mk"); shout(greeting)`, "hello!"},
+		{`let {x, y, norm} = import("../testdata/lib/point.mk"); x + y + norm()`, 32},
+		{`import "../testdata/lib/macros.mk"; macros["double"](21)`, 42},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			obj := Eval(program, object.NewEnvironment())
+			switch want := tt.wantValue.(type) {
+			case int:
+				iobj, ok := obj.(*object.Integer)
+				if !ok || iobj.Value != int64(want) {
+					t.Fatalf("value want %d, but got %+v", want, obj)
+				}
+			case string:
+				sobj, ok := obj.(*object.String)
+				if !ok || sobj.Value != want {
+					t.Fatalf("value want %q, but got %+v", want, obj)
+				}
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`import "../testdata/lib/strings.mk"; strings["count"]`, "ERROR: 1:45: NameError: module ../testdata/lib/strings.mk has no export count"},
+		{`import "../testdata/cycle_a.mk"`, "ERROR: ../testdata/cycle_b.mk:1:1: ImportError: import cycle: ../testdata/cycle_a.mk -> ../testdata/cycle_b.mk -> ../testdata/cycle_a.mk"},
+		{`import "../testdata/broken.mk"`, "ERROR: 1:1: ImportError: ../testdata/broken.mk:1:9: no prefix fn found ;"},
+		{`import "../testdata/fail.mk"`, "ERROR: ../testdata/fail.mk:1:18: ZeroDivisionError: division by zero"},
+		{`import("../testdata/missing.mk")`, "ERROR: 1:1: ImportError: open ../testdata/missing.mk: no such file or directory"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			program := p.ParseProgram()
+			obj := Eval(program, object.NewEnvironment())
+			if obj.Inspect() != tt.want {
+				t.Fatalf("error want %q, but got %q", tt.want, obj.Inspect())
+			}
+		})
+	}
+}
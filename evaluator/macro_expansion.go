@@ -0,0 +1,112 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/lqqyt2423/go-monkey/ast"
+	"github.com/lqqyt2423/go-monkey/object"
+	"github.com/lqqyt2423/go-monkey/parser"
+)
+
+// ParseModule parses the file of an imported module and expands the macros
+// it defines, which only apply within the module.
+func ParseModule(file string) (*ast.Program, error) {
+	program, err := parser.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, err := ExpandMacros(program, env)
+	if err != nil {
+		return nil, err
+	}
+	return expanded.(*ast.Program), nil
+}
+
+// DefineMacros binds the macros defined by top-level let statements in env
+// and removes their definitions from the program.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	statements := program.Statements[:0]
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			statements = append(statements, stmt)
+			continue
+		}
+		macro, ok := let.Value.(*ast.MacroLiteral)
+		if !ok || let.Name == nil {
+			statements = append(statements, stmt)
+			continue
+		}
+		env.Set(let.Name.Value, &object.Macro{
+			Parameters: macro.Parameters,
+			Body:       macro.Body,
+			Env:        env,
+		})
+	}
+	program.Statements = statements
+}
+
+// ExpandMacros replaces every call to a macro defined in env with the code
+// the macro returns for the quoted arguments of the call.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	var err error
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || err != nil {
+			return node
+		}
+		macro, ok := macroCalled(call, env)
+		if !ok {
+			return node
+		}
+		if len(call.Keywords) > 0 {
+			err = fmt.Errorf("%s: %s", call.Pos(), object.NewError(object.ArityError, "unknown keyword argument: %s", call.Keywords[0].Name.Value))
+			return node
+		}
+		if len(call.Arguments) != len(macro.Parameters) {
+			err = fmt.Errorf("%s: %s", call.Pos(), object.NewArityError(len(macro.Parameters), len(call.Arguments)))
+			return node
+		}
+
+		args := make([]object.Object, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = &object.Quote{Node: arg}
+		}
+		evaluated := Eval(macro.Body, object.ExtendEnvironment(macro.Parameters, args, macro.Env))
+		if rval, ok := evaluated.(*object.ReturnValue); ok {
+			evaluated = rval.Value
+		}
+		switch evaluated := evaluated.(type) {
+		case *object.Quote:
+			return evaluated.Node
+		case *object.Error:
+			err = fmt.Errorf("%s: in macro %s: %s", call.Pos(), call.Function, evaluated.Error())
+		default:
+			err = fmt.Errorf("%s: macro %s returned %s, not a quote", call.Pos(), call.Function, typeOf(evaluated))
+		}
+		return node
+	})
+	return expanded, err
+}
+
+func macroCalled(call *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := env.Get(ident.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func typeOf(obj object.Object) object.ObjectType {
+	if obj == nil {
+		return object.NULL_OBJ
+	}
+	return obj.Type()
+}
@@ -0,0 +1,161 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/lqqyt2423/go-monkey/ast"
+	"github.com/lqqyt2423/go-monkey/lexer"
+	"github.com/lqqyt2423/go-monkey/object"
+	"github.com/lqqyt2423/go-monkey/parser"
+)
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+let number = 1;
+let function = fn(x, y) { x + y };
+let mymacro = macro(x, y) { x + y; };
+`
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+	if macro.Body.String() != "(x + y)" {
+		t.Fatalf("body is not %q. got=%q", "(x + y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			`
+let infixExpression = macro() { quote(1 + 2); };
+infixExpression();
+`,
+			`(1 + 2)`,
+		},
+		{
+			`
+let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+reverse(2 + 2, 10 - 5);
+`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+let unless = macro(condition, consequence, alternative) {
+  quote(if (!(unquote(condition))) {
+    unquote(consequence);
+  } else {
+    unquote(alternative);
+  });
+};
+unless(10 > 5, puts("not greater"), puts("greater"));
+`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`
+let twice = macro(x) { quote(unquote(x) * 2) };
+twice(1) + twice(twice(3));
+`,
+			`(1 * 2) + ((3 * 2) * 2)`,
+		},
+		{
+			`
+let source = macro(x) { quote(unquote("${x}")) };
+source(a + b);
+`,
+			`"(a + b)"`,
+		},
+	}
+	for _, tt := range tests {
+		want := testParseProgram(tt.want)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("%q: macro expansion failed: %s", tt.input, err)
+		}
+		if expanded.String() != want.String() {
+			t.Errorf("not equal. want=%q, got=%q", want.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"let m = macro(x) { 1 }; m(2)", "1:26: macro m returned INTEGER, not a quote"},
+		{"let m = macro(x) { quote(x) }; m()", "1:33: ArityError: wrong number of arguments: want=1, got=0"},
+		{"let m = macro() { 1 / 0 }; m()", "1:29: in macro m: ZeroDivisionError: division by zero"},
+	}
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil {
+			t.Fatalf("%q: expected macro expansion error", tt.input)
+		}
+		if err.Error() != tt.wantErr {
+			t.Fatalf("%q: error want %q, but got %q", tt.input, tt.wantErr, err.Error())
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	input := `
+let assert = macro(cond) {
+  quote(if (!(unquote(cond))) { throw "assertion failed: " + unquote("${cond}"); })
+};
+let x = 3;
+assert(x == 3);
+try { assert(x > 5) } catch (e) { e }
+`
+	program := testParseProgram(input)
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	expanded, err := ExpandMacros(program, macroEnv)
+	if err != nil {
+		t.Fatalf("macro expansion failed: %s", err)
+	}
+	evaluated := Eval(expanded, object.NewEnvironment())
+	str, ok := evaluated.(*object.String)
+	if !ok || str.Value != "assertion failed: (x > 5)" {
+		t.Fatalf("value want %q, but got %+v", "assertion failed: (x > 5)", evaluated)
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
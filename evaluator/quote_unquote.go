@@ -0,0 +1,79 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/lqqyt2423/go-monkey/ast"
+	"github.com/lqqyt2423/go-monkey/object"
+	"github.com/lqqyt2423/go-monkey/token"
+)
+
+// quote returns node unevaluated, with the unquote calls in it replaced by
+// their values.
+func quote(node ast.Node, env *object.Environment) object.Object {
+	var err object.Object
+	fail := func(call *ast.CallExpression, e *object.Error) {
+		e.Pos = call.Pos()
+		err = e
+	}
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || !isCallTo(call, "unquote") || err != nil {
+			return node
+		}
+		if len(call.Arguments) != 1 {
+			fail(call, object.NewArityError(1, len(call.Arguments)))
+			return node
+		}
+		unquoted := Eval(call.Arguments[0], env)
+		if isError(unquoted) {
+			err = unquoted
+			return node
+		}
+		converted := convertObjectToASTNode(unquoted, call.Pos())
+		if converted == nil {
+			fail(call, object.NewError(object.TypeError, "cannot unquote %s", unquoted.Type()))
+			return node
+		}
+		return converted
+	})
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+func isCallTo(call *ast.CallExpression, name string) bool {
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// convertObjectToASTNode turns the value of an unquote call back into code,
+// positioned at the call. It returns nil for values that have no literal.
+func convertObjectToASTNode(obj object.Object, pos token.Position) ast.Node {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value), Pos: pos}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+	case *object.BigInteger:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String(), Pos: pos}
+		return &ast.IntegerLiteral{Token: t, Big: obj.Value}
+	case *object.Float:
+		t := token.Token{Type: token.FLOAT, Literal: strconv.FormatFloat(obj.Value, 'g', -1, 64), Pos: pos}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: pos}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}
+	case *object.Boolean:
+		t := token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}
+	case *object.Quote:
+		return obj.Node
+	default:
+		return nil
+	}
+}
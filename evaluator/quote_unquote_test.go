@@ -0,0 +1,70 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/lqqyt2423/go-monkey/object"
+)
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"quote(5)", "5"},
+		{"quote(5 + 8)", "(5 + 8)"},
+		{"quote(foobar)", "foobar"},
+		{"quote(foobar + barfoo)", "(foobar + barfoo)"},
+		{"quote(unquote(4))", "4"},
+		{"quote(unquote(4 + 4))", "8"},
+		{"quote(8 + unquote(4 + 4))", "(8 + 8)"},
+		{"quote(unquote(4 + 4) + 8)", "(8 + 8)"},
+		{"let foobar = 8; quote(foobar)", "foobar"},
+		{"let foobar = 8; quote(unquote(foobar))", "8"},
+		{"quote(unquote(true))", "true"},
+		{"quote(unquote(true == false))", "false"},
+		{"quote(unquote(1.5))", "1.5"},
+		{`quote(unquote("a" + "b"))`, `"ab"`},
+		{"quote(unquote(quote(4 + 4)))", "(4 + 4)"},
+		{"let quotedInfix = quote(4 + 4); quote(unquote(4 + 4) + unquote(quotedInfix))", "(8 + (4 + 4))"},
+		{"let f = fn(x) { quote(unquote(x) * 2) }; f(1); f(3)", "(3 * 2)"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEvalProgram(tt.input)
+			quote, ok := evaluated.(*object.Quote)
+			if !ok {
+				t.Fatalf("expected *object.Quote, but got %T (%+v)", evaluated, evaluated)
+			}
+			if quote.Node.String() != tt.want {
+				t.Fatalf("quote.Node.String() want %q, but got %q", tt.want, quote.Node.String())
+			}
+		})
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"quote(1, 2)", "ERROR: 1:6: ArityError: wrong number of arguments: want=1, got=2"},
+		{"quote(unquote(1, 2))", "ERROR: 1:14: ArityError: wrong number of arguments: want=1, got=2"},
+		{"quote(unquote([1]))", "ERROR: 1:14: TypeError: cannot unquote ARRAY"},
+		{"quote(unquote(1 / 0))", "ERROR: 1:17: ZeroDivisionError: division by zero"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEvalProgram(tt.input)
+			if evaluated.Inspect() != tt.want {
+				t.Fatalf("error want %q, but got %q", tt.want, evaluated.Inspect())
+			}
+		})
+	}
+}
+
+func testEvalProgram(input string) object.Object {
+	return Eval(testParseProgram(input), object.NewEnvironment())
+}
@@ -1,26 +1,75 @@
 package lexer
 
-import "github.com/lqqyt2423/go-monkey/token"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lqqyt2423/go-monkey/token"
+)
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+
+	// filename, line and column locate ch for token positions.
+	filename string
+	line     int
+	column   int
+
+	// interpolations holds, for every ${ still open, how many braces are
+	// open inside it, so the } that closes it can be told apart.
+	interpolations []int
+
+	// keepComments makes comments come out as COMMENT tokens instead of
+	// being skipped.
+	keepComments bool
 }
 
 func New(input string) *Lexer {
 	l := &Lexer{
 		input: input,
+		line:  1,
 	}
 	l.readChar()
 	return l
 }
 
+// NewFile is like New, but the positions of the tokens name the file the
+// input was read from.
+func NewFile(filename, input string) *Lexer {
+	l := New(input)
+	l.filename = filename
+	return l
+}
+
+// NewWithComments is like New, but the lexer also returns comments, for
+// tools such as formatters that need to keep them.
+func NewWithComments(input string) *Lexer {
+	l := New(input)
+	l.keepComments = true
+	return l
+}
+
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
+		pos := token.Position{Filename: l.filename, Line: l.line, Column: l.column}
+		tok := l.readToken()
+		if tok.Type == token.COMMENT && !l.keepComments {
+			continue
+		}
+		tok.Pos = pos
+		return tok
+	}
+}
 
-	l.skipWhitespace()
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -28,6 +77,10 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok.Type = token.EQ
 			tok.Literal = "=="
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok.Type = token.ARROW
+			tok.Literal = "=>"
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -43,22 +96,78 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok.Type = token.AND
+			tok.Literal = "&&"
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok.Type = token.OR
+			tok.Literal = "||"
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '/':
+		if l.peekChar() == '/' || l.peekChar() == '*' {
+			return l.readCommentToken()
+		}
 		tok = newToken(token.SLASH, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok.Type = token.LT_EQ
+			tok.Literal = "<="
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tok.Type = token.SHL
+			tok.Literal = "<<"
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok.Type = token.GT_EQ
+			tok.Literal = ">="
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok.Type = token.SHR
+			tok.Literal = ">>"
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch)
+		if n := len(l.interpolations); n > 0 && l.interpolations[n-1] == 0 {
+			l.interpolations = l.interpolations[:n-1]
+			tok = l.readStringToken(token.INTERP_MID, token.INTERP_END)
+		} else {
+			if n > 0 {
+				l.interpolations[n-1]--
+			}
+			tok = newToken(token.RBRACE, l.ch)
+		}
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -69,23 +178,43 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.readChar()
+			l.readChar()
+			tok.Type = token.ELLIPSIS
+			tok.Literal = "..."
+		} else {
+			tok = illegalToken(fmt.Errorf("illegal character %q", l.ch))
+		}
 	case 0:
-		tok.Type = token.EOF
-		tok.Literal = ""
+		if len(l.interpolations) > 0 {
+			l.interpolations = nil
+			tok = illegalToken(errors.New("unterminated string interpolation"))
+		} else {
+			tok.Type = token.EOF
+			tok.Literal = ""
+		}
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readStringToken(token.INTERP_START, token.STRING)
+	case '`':
+		str, err := l.readRawString()
+		if err != nil {
+			tok = illegalToken(err)
+		} else {
+			tok.Type = token.STRING
+			tok.Literal = str
+		}
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = illegalToken(fmt.Errorf("illegal character %q", l.ch))
 		}
 	}
 
@@ -94,6 +223,12 @@ func (l *Lexer) NextToken() token.Token {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -111,23 +246,171 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a float when the digits are followed by
+// a fraction or an exponent.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	typ := token.INT
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		typ = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		exp := l.readPosition
+		if exp < len(l.input) && (l.input[exp] == '+' || l.input[exp] == '-') {
+			exp++
+		}
+		if exp < len(l.input) && isDigit(l.input[exp]) {
+			typ = token.FLOAT
+			for l.readPosition < exp {
+				l.readChar()
+			}
+			l.readChar()
+			l.readDigits()
+		}
+	}
+	return l.input[position:l.position], typ
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
-	l.readChar()
+// readStringToken reads the part of a double quoted string up to its
+// closing quote, giving a tokenType token, or up to the next ${, giving an
+// interpType token and opening an interpolation.
+func (l *Lexer) readStringToken(interpType, tokenType token.TokenType) token.Token {
+	str, interpolated, err := l.readString()
+	if interpolated {
+		l.interpolations = append(l.interpolations, 0)
+	}
+	switch {
+	case err != nil:
+		return illegalToken(err)
+	case interpolated:
+		return token.Token{Type: interpType, Literal: str}
+	default:
+		return token.Token{Type: tokenType, Literal: str}
+	}
+}
+
+// readString reads a double quoted string and decodes its escape
+// sequences, which follow Go's: \n, \t, \", \\, \xff, \u00e9 and so on,
+// plus \$ for a literal $. It stops early at a ${ and reports so.
+func (l *Lexer) readString() (string, bool, error) {
+	var out strings.Builder
+	var err error
+	for {
+		l.readChar()
+		switch {
+		case l.atEOF():
+			return "", false, errors.New("unterminated string literal")
+		case l.ch == '"':
+			return out.String(), false, err
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			return out.String(), true, err
+		case l.ch == '\\' && l.peekChar() == '$':
+			l.readChar()
+			out.WriteByte('$')
+		case l.ch == '\\':
+			rest := l.input[l.position:]
+			value, multibyte, tail, escErr := strconv.UnquoteChar(rest, '"')
+			if escErr != nil {
+				if err == nil {
+					_, size := utf8.DecodeRuneInString(rest[1:])
+					err = fmt.Errorf("invalid escape sequence %s in string literal", rest[:1+size])
+				}
+				// skip the escaped character so \" does not end the string
+				l.readChar()
+				continue
+			}
+			if multibyte {
+				out.WriteRune(value)
+			} else {
+				out.WriteByte(byte(value))
+			}
+			// leave l.ch on the last character of the escape sequence
+			for i := len(rest) - len(tail); i > 1; i-- {
+				l.readChar()
+			}
+		default:
+			out.WriteByte(l.ch)
+		}
+	}
+}
+
+// readRawString reads a backquoted string, which may span lines and has
+// no escape sequences.
+func (l *Lexer) readRawString() (string, error) {
+	position := l.position + 1
+	for {
+		l.readChar()
+		if l.atEOF() {
+			return "", errors.New("unterminated raw string literal")
+		}
+		if l.ch == '`' {
+			return l.input[position:l.position], nil
+		}
+	}
+}
+
+// readCommentToken reads a // or /* */ comment.
+func (l *Lexer) readCommentToken() token.Token {
+	var comment string
+	var err error
+	if l.peekChar() == '/' {
+		comment = l.readLineComment()
+	} else {
+		comment, err = l.readBlockComment()
+	}
+	if err != nil {
+		return illegalToken(err)
+	}
+	return token.Token{Type: token.COMMENT, Literal: comment}
+}
+
+// readLineComment reads up to the end of the line, leaving l.ch on the
+// newline.
+func (l *Lexer) readLineComment() string {
 	position := l.position
-	for l.ch != '"' {
+	for l.ch != '\n' && !l.atEOF() {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
+// readBlockComment reads a block comment, in which other block comments
+// may be nested, leaving l.ch just after its closing */.
+func (l *Lexer) readBlockComment() (string, error) {
+	position := l.position
+	depth := 0
+	for !l.atEOF() {
+		switch {
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return l.input[position:l.position], nil
+			}
+		}
+		l.readChar()
+	}
+	return "", errors.New("unterminated block comment")
+}
+
+func (l *Lexer) atEOF() bool {
+	return l.position >= len(l.input)
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\n' || l.ch == '\r' || l.ch == '\t' {
 		l.readChar()
@@ -141,6 +424,15 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPosition]
 }
 
+// illegalToken describes what is wrong in its literal, which the parser
+// reports as is.
+func illegalToken(err error) token.Token {
+	return token.Token{
+		Type:    token.ILLEGAL,
+		Literal: err.Error(),
+	}
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{
 		Type:    tokenType,
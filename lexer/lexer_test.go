@@ -9,12 +9,18 @@ import (
 func TestNextTokenSimple(t *testing.T) {
 	input := `=+(){},;-*/<>!
 true false if else return
-== !=
+while break continue for in
+try catch finally throw import export macro match
+== != && || <= >=
+% & | ^ ~ << >>
+3.14 1e-9 2E+3 4e 5.
 "hello"
 "world"
 1
 []
 :
+... .. .
+=>
 `
 	tests := []struct {
 		wantType    token.TokenType
@@ -39,14 +45,50 @@ true false if else return
 		{token.IF, "if"},
 		{token.ELSE, "else"},
 		{token.RETURN, "return"},
+		{token.WHILE, "while"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.TRY, "try"},
+		{token.CATCH, "catch"},
+		{token.FINALLY, "finally"},
+		{token.THROW, "throw"},
+		{token.IMPORT, "import"},
+		{token.EXPORT, "export"},
+		{token.MACRO, "macro"},
+		{token.MATCH, "match"},
 		{token.EQ, "=="},
 		{token.NOT_EQ, "!="},
+		{token.AND, "&&"},
+		{token.OR, "||"},
+		{token.LT_EQ, "<="},
+		{token.GT_EQ, ">="},
+		{token.PERCENT, "%"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.SHL, "<<"},
+		{token.SHR, ">>"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2E+3"},
+		{token.INT, "4"},
+		{token.IDENT, "e"},
+		{token.INT, "5"},
+		{token.ILLEGAL, "illegal character '.'"},
 		{token.STRING, "hello"},
 		{token.STRING, "world"},
 		{token.INT, "1"},
 		{token.LBRACKET, "["},
 		{token.RBRACKET, "]"},
 		{token.COLON, ":"},
+		{token.ELLIPSIS, "..."},
+		{token.ILLEGAL, "illegal character '.'"},
+		{token.ILLEGAL, "illegal character '.'"},
+		{token.ILLEGAL, "illegal character '.'"},
+		{token.ARROW, "=>"},
 	}
 
 	l := New(input)
@@ -130,3 +172,199 @@ let result = add(five, ten);
 		}
 	}
 }
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantType    token.TokenType
+		wantLiteral string
+	}{
+		{`"plain"`, token.STRING, "plain"},
+		{`"a\nb\tc"`, token.STRING, "a\nb\tc"},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"café"`, token.STRING, "café"},
+		{`"\x41\101"`, token.STRING, "AA"},
+		{`"héllo"`, token.STRING, "héllo"},
+		{"\"multi\nline\"", token.STRING, "multi\nline"},
+		{"`raw \\n \"string\"`", token.STRING, `raw \n "string"`},
+		{"`two\nlines`", token.STRING, "two\nlines"},
+		{`"open`, token.ILLEGAL, "unterminated string literal"},
+		{`"ends with \"`, token.ILLEGAL, "unterminated string literal"},
+		{"`open", token.ILLEGAL, "unterminated raw string literal"},
+		{`"bad \q escape"`, token.ILLEGAL, `invalid escape sequence \q in string literal`},
+		{`"\u12"`, token.ILLEGAL, `invalid escape sequence \u in string literal`},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input + "; x")
+		tok := l.NextToken()
+		if tok.Type != tt.wantType {
+			t.Fatalf("%s: token type want %q, but got %q", tt.input, tt.wantType, tok.Type)
+		}
+		if tok.Literal != tt.wantLiteral {
+			t.Fatalf("%s: token literal want %q, but got %q", tt.input, tt.wantLiteral, tok.Literal)
+		}
+	}
+
+	// lexing carries on after a bad escape
+	l := New(`"\q" x`)
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.IDENT || tok.Literal != "x" {
+		t.Fatalf("token after invalid string want IDENT x, but got %q %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []token.Token
+	}{
+		{
+			`"hello ${name}, you have ${len(items)} items"`,
+			[]token.Token{
+				{Type: token.INTERP_START, Literal: "hello "},
+				{Type: token.IDENT, Literal: "name"},
+				{Type: token.INTERP_MID, Literal: ", you have "},
+				{Type: token.IDENT, Literal: "len"},
+				{Type: token.LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "items"},
+				{Type: token.RPAREN, Literal: ")"},
+				{Type: token.INTERP_END, Literal: " items"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			`"${ {"k": "<${x}>"}["k"] }!"`,
+			[]token.Token{
+				{Type: token.INTERP_START, Literal: ""},
+				{Type: token.LBRACE, Literal: "{"},
+				{Type: token.STRING, Literal: "k"},
+				{Type: token.COLON, Literal: ":"},
+				{Type: token.INTERP_START, Literal: "<"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.INTERP_END, Literal: ">"},
+				{Type: token.RBRACE, Literal: "}"},
+				{Type: token.LBRACKET, Literal: "["},
+				{Type: token.STRING, Literal: "k"},
+				{Type: token.RBRACKET, Literal: "]"},
+				{Type: token.INTERP_END, Literal: "!"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			`"cost: \${x} $5" ${`,
+			[]token.Token{
+				{Type: token.STRING, Literal: "cost: ${x} $5"},
+				{Type: token.ILLEGAL, Literal: "illegal character '$'"},
+				{Type: token.LBRACE, Literal: "{"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			`"a ${x`,
+			[]token.Token{
+				{Type: token.INTERP_START, Literal: "a "},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ILLEGAL, Literal: "unterminated string interpolation"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, want := range tt.want {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Fatalf("%s: token %d want %+v, but got %+v", tt.input, i, want, tok)
+			}
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 10 / 2; // trailing
+/* block /* nested */ still comment */ x
+/* unterminated /* nested */`
+	tests := []struct {
+		keepComments bool
+		want         []token.Token
+	}{
+		{
+			false,
+			[]token.Token{
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.INT, Literal: "10"},
+				{Type: token.SLASH, Literal: "/"},
+				{Type: token.INT, Literal: "2"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ILLEGAL, Literal: "unterminated block comment"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			true,
+			[]token.Token{
+				{Type: token.COMMENT, Literal: "// leading"},
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.INT, Literal: "10"},
+				{Type: token.SLASH, Literal: "/"},
+				{Type: token.INT, Literal: "2"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.COMMENT, Literal: "// trailing"},
+				{Type: token.COMMENT, Literal: "/* block /* nested */ still comment */"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ILLEGAL, Literal: "unterminated block comment"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := New(input)
+		if tt.keepComments {
+			l = NewWithComments(input)
+		}
+		for i, want := range tt.want {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Fatalf("keepComments=%v: token %d want %+v, but got %+v", tt.keepComments, i, want, tok)
+			}
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"a\nb\";\n/* c */ y"
+	tests := []struct {
+		wantLiteral string
+		wantPos     token.Position
+	}{
+		{"let", token.Position{Filename: "main.mk", Line: 1, Column: 1}},
+		{"x", token.Position{Filename: "main.mk", Line: 1, Column: 5}},
+		{"=", token.Position{Filename: "main.mk", Line: 1, Column: 7}},
+		{"5", token.Position{Filename: "main.mk", Line: 1, Column: 9}},
+		{";", token.Position{Filename: "main.mk", Line: 1, Column: 10}},
+		{"x", token.Position{Filename: "main.mk", Line: 2, Column: 3}},
+		{"+", token.Position{Filename: "main.mk", Line: 2, Column: 5}},
+		{"a\nb", token.Position{Filename: "main.mk", Line: 2, Column: 7}},
+		{";", token.Position{Filename: "main.mk", Line: 3, Column: 3}},
+		{"y", token.Position{Filename: "main.mk", Line: 4, Column: 9}},
+		{"", token.Position{Filename: "main.mk", Line: 4, Column: 10}},
+	}
+
+	l := NewFile("main.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.wantLiteral || tok.Pos != tt.wantPos {
+			t.Fatalf("tests[%d]: want %q at %s, but got %q at %s", i, tt.wantLiteral, tt.wantPos, tok.Literal, tok.Pos)
+		}
+	}
+}
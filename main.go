@@ -7,6 +7,12 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if !repl.RunFile(os.Args[1], os.Stderr) {
+			os.Exit(1)
+		}
+		return
+	}
 	// repl.Start(os.Stdin, os.Stdout)
 	repl.StartVM(os.Stdin, os.Stdout)
 }
@@ -2,6 +2,10 @@ package object
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 )
 
 var Builtins = []struct {
@@ -13,7 +17,7 @@ var Builtins = []struct {
 		&Builtin{
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
-					return newError("arguments len %d mismatch, want %d", len(args), 1)
+					return NewArityError(1, len(args))
 				}
 				arg := args[0]
 				switch argObj := arg.(type) {
@@ -23,8 +27,10 @@ var Builtins = []struct {
 					return &Integer{Value: int64(len(argObj.Elements))}
 				case *Hash:
 					return &Integer{Value: int64(len(argObj.Pairs))}
+				case *Module:
+					return &Integer{Value: int64(len(argObj.Exports.Pairs))}
 				default:
-					return newError("type mismatch: %s", arg.Type())
+					return NewError(TypeError, "argument to len not supported: %s", arg.Type())
 				}
 			},
 		},
@@ -40,6 +46,62 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"int",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return NewArityError(1, len(args))
+				}
+				arg := args[0]
+				switch argObj := arg.(type) {
+				case *Integer:
+					return argObj
+				case *BigInteger:
+					return argObj
+				case *Float:
+					if math.IsNaN(argObj.Value) || math.IsInf(argObj.Value, 0) {
+						return NewError(ValueError, "float %s out of integer range", argObj.Inspect())
+					}
+					v, _ := big.NewFloat(argObj.Value).Int(nil)
+					return NewInteger(v)
+				case *String:
+					v, ok := new(big.Int).SetString(strings.TrimSpace(argObj.Value), 10)
+					if !ok {
+						return NewError(ValueError, "could not parse %s as integer", argObj.Inspect())
+					}
+					return NewInteger(v)
+				default:
+					return NewError(TypeError, "argument to int not supported: %s", arg.Type())
+				}
+			},
+		},
+	},
+	{
+		"float",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return NewArityError(1, len(args))
+				}
+				arg := args[0]
+				switch argObj := arg.(type) {
+				case *Integer, *BigInteger:
+					return &Float{Value: IntegerToFloat(argObj)}
+				case *Float:
+					return argObj
+				case *String:
+					v, err := strconv.ParseFloat(strings.TrimSpace(argObj.Value), 64)
+					if err != nil {
+						return NewError(ValueError, "could not parse %s as float", argObj.Inspect())
+					}
+					return &Float{Value: v}
+				default:
+					return NewError(TypeError, "argument to float not supported: %s", arg.Type())
+				}
+			},
+		},
+	},
 }
 
 func GetBuiltinByName(name string) *Builtin {
@@ -50,9 +112,3 @@ func GetBuiltinByName(name string) *Builtin {
 	}
 	return nil
 }
-
-func newError(format string, a ...any) Object {
-	return &Error{
-		Message: fmt.Sprintf(format, a...),
-	}
-}
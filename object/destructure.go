@@ -0,0 +1,61 @@
+package object
+
+import "fmt"
+
+// DestructureArray returns the n elements of the array obj for an array
+// pattern, followed by an array of the remaining elements if rest is set.
+func DestructureArray(obj Object, n int, rest bool) ([]Object, *Error) {
+	arr, ok := obj.(*Array)
+	if !ok {
+		return nil, NewError(TypeError, "cannot destructure %s as an array", obj.Type())
+	}
+	if rest && len(arr.Elements) < n {
+		return nil, NewError(ValueError, "cannot destructure array of length %d into at least %s", len(arr.Elements), elements(n))
+	}
+	if !rest && len(arr.Elements) != n {
+		return nil, NewError(ValueError, "cannot destructure array of length %d into %s", len(arr.Elements), elements(n))
+	}
+
+	values := make([]Object, n, n+1)
+	copy(values, arr.Elements)
+	if rest {
+		remaining := make([]Object, len(arr.Elements)-n)
+		copy(remaining, arr.Elements[n:])
+		values = append(values, &Array{Elements: remaining})
+	}
+	return values, nil
+}
+
+// elements describes a count of n array elements.
+func elements(n int) string {
+	if n == 1 {
+		return "1 element"
+	}
+	return fmt.Sprintf("%d elements", n)
+}
+
+// DestructureHash returns the values under keys of the hash or module obj
+// for a hash pattern.
+func DestructureHash(obj Object, keys []string) ([]Object, *Error) {
+	if obj.Type() != HASH_OBJ && obj.Type() != MODULE_OBJ {
+		return nil, NewError(TypeError, "cannot destructure %s as a hash", obj.Type())
+	}
+	values := make([]Object, len(keys))
+	for i, key := range keys {
+		name := &String{Value: key}
+		if mod, ok := obj.(*Module); ok {
+			value, err := mod.Export(name)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+			continue
+		}
+		pair, ok := obj.(*Hash).Pairs[name.HashKey()]
+		if !ok {
+			return nil, NewError(ValueError, "cannot destructure hash without key %q", key)
+		}
+		values[i] = pair.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,204 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// IsInteger reports whether obj is an Integer or a BigInteger.
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInteger:
+		return true
+	}
+	return false
+}
+
+// NewInteger returns v as an Integer when it fits in an int64 and as a
+// BigInteger otherwise.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+func bigValue(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	}
+	return nil
+}
+
+// IntegerOperation applies a binary integer operator to two Integer or
+// BigInteger operands. Results that overflow an int64 are promoted to a
+// BigInteger, so both engines agree on every result.
+func IntegerOperation(operator string, left, right Object) (Object, *Error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok, err := smallIntegerOperation(operator, l.Value, r.Value); ok || err != nil {
+			return result, err
+		}
+	}
+	return bigIntegerOperation(operator, bigValue(left), bigValue(right))
+}
+
+// smallIntegerOperation reports false when the result does not fit in an
+// int64 and has to be computed with math/big instead.
+func smallIntegerOperation(operator string, l, r int64) (Object, bool, *Error) {
+	var result int64
+	switch operator {
+	case "+":
+		result = l + r
+		if (result^l)&(result^r) < 0 {
+			return nil, false, nil
+		}
+	case "-":
+		result = l - r
+		if (l^r)&(l^result) < 0 {
+			return nil, false, nil
+		}
+	case "*":
+		if l == 0 || r == 0 {
+			return &Integer{Value: 0}, true, nil
+		}
+		result = l * r
+		if result/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return nil, false, nil
+		}
+	case "/":
+		if r == 0 {
+			return nil, false, NewError(ZeroDivisionError, "division by zero")
+		}
+		if l == math.MinInt64 && r == -1 {
+			return nil, false, nil
+		}
+		result = l / r
+	case "%":
+		if r == 0 {
+			return nil, false, NewError(ZeroDivisionError, "division by zero")
+		}
+		result = l % r
+	case "&":
+		result = l & r
+	case "|":
+		result = l | r
+	case "^":
+		result = l ^ r
+	case "<<":
+		if r < 0 {
+			return nil, false, NewError(ValueError, "negative shift count: %d", r)
+		}
+		if r >= 63 || (l<<r)>>r != l {
+			return nil, false, nil
+		}
+		result = l << r
+	case ">>":
+		if r < 0 {
+			return nil, false, NewError(ValueError, "negative shift count: %d", r)
+		}
+		result = l >> r
+	default:
+		return nil, false, NewError(TypeError, "unknown operator: INTEGER %s INTEGER", operator)
+	}
+	return &Integer{Value: result}, true, nil
+}
+
+func bigIntegerOperation(operator string, l, r *big.Int) (Object, *Error) {
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(l, r)
+	case "-":
+		result.Sub(l, r)
+	case "*":
+		result.Mul(l, r)
+	case "/", "%":
+		if r.Sign() == 0 {
+			return nil, NewError(ZeroDivisionError, "division by zero")
+		}
+		// Quo and Rem truncate like the int64 operators
+		if operator == "/" {
+			result.Quo(l, r)
+		} else {
+			result.Rem(l, r)
+		}
+	case "&":
+		result.And(l, r)
+	case "|":
+		result.Or(l, r)
+	case "^":
+		result.Xor(l, r)
+	case "<<", ">>":
+		if r.Sign() < 0 {
+			return nil, NewError(ValueError, "negative shift count: %s", r)
+		}
+		if !r.IsInt64() || r.Int64() > math.MaxInt32 {
+			return nil, NewError(ValueError, "shift count too large: %s", r)
+		}
+		if operator == "<<" {
+			result.Lsh(l, uint(r.Int64()))
+		} else {
+			result.Rsh(l, uint(r.Int64()))
+		}
+	default:
+		return nil, NewError(TypeError, "unknown operator: INTEGER %s INTEGER", operator)
+	}
+	return NewInteger(result), nil
+}
+
+// CompareIntegers returns -1, 0 or +1 as left is less than, equal to or
+// greater than right.
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		}
+		return 0
+	}
+	return bigValue(left).Cmp(bigValue(right))
+}
+
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewInteger(new(big.Int).Neg(bigValue(obj)))
+}
+
+func BitNotInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok {
+		return &Integer{Value: ^i.Value}
+	}
+	return NewInteger(new(big.Int).Not(bigValue(obj)))
+}
+
+// IntegerToFloat converts an Integer or BigInteger to the nearest float64.
+func IntegerToFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	f, _ := new(big.Float).SetInt(bigValue(obj)).Float64()
+	return f
+}
+
+// FloatValue widens an integer or float operand for mixed arithmetic.
+func FloatValue(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer, *BigInteger:
+		return IntegerToFloat(obj), true
+	case *Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
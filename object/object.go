@@ -2,15 +2,26 @@ package object
 
 import (
 	"fmt"
+	"hash/fnv"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/lqqyt2423/go-monkey/ast"
 	"github.com/lqqyt2423/go-monkey/code"
+	"github.com/lqqyt2423/go-monkey/token"
 )
 
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	// modules is set on the top-level environments of a program and the
+	// modules it imports, which share it.
+	modules *Modules
+	// depth is the number of function calls in progress where the
+	// environment is used, to stop runaway recursion.
+	depth int
 }
 
 func NewEnvironment() *Environment {
@@ -19,6 +30,51 @@ func NewEnvironment() *Environment {
 	}
 }
 
+// NewModuleEnvironment returns the top-level environment for a module
+// imported from env.
+func NewModuleEnvironment(env *Environment) *Environment {
+	return &Environment{
+		store:   make(map[string]Object),
+		modules: env.Modules(),
+		depth:   env.depth,
+	}
+}
+
+// NewEnclosedEnvironment returns the environment for a block within outer.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	return &Environment{
+		store: make(map[string]Object),
+		outer: outer,
+		depth: outer.depth,
+	}
+}
+
+// NewCallEnvironment returns the environment for a call, made from the
+// environment caller, to a function closed over outer.
+func NewCallEnvironment(outer, caller *Environment) *Environment {
+	return &Environment{
+		store: make(map[string]Object),
+		outer: outer,
+		depth: caller.depth + 1,
+	}
+}
+
+// Depth is the number of function calls in progress where env is used.
+func (env *Environment) Depth() int {
+	return env.depth
+}
+
+// Modules returns the modules imported by the program env belongs to.
+func (env *Environment) Modules() *Modules {
+	for env.outer != nil {
+		env = env.outer
+	}
+	if env.modules == nil {
+		env.modules = &Modules{loaded: make(map[string]*Module)}
+	}
+	return env.modules
+}
+
 func ExtendEnvironment(variables []*ast.Identifier, values []Object, baseEnv *Environment) *Environment {
 	store := make(map[string]Object)
 	for i, key := range variables {
@@ -35,6 +91,19 @@ func (env *Environment) Set(key string, val Object) Object {
 	return val
 }
 
+// Assign rebinds key in the innermost environment that defines it and
+// reports false if no environment does.
+func (env *Environment) Assign(key string, val Object) bool {
+	if _, ok := env.store[key]; ok {
+		env.store[key] = val
+		return true
+	}
+	if env.outer != nil {
+		return env.outer.Assign(key, val)
+	}
+	return false
+}
+
 func (env *Environment) Get(key string) (Object, bool) {
 	obj, ok := env.store[key]
 	if !ok && env.outer != nil {
@@ -52,16 +121,23 @@ type Object interface {
 
 const (
 	INTEGER_OBJ           ObjectType = "INTEGER"
+	FLOAT_OBJ             ObjectType = "FLOAT"
 	STRING_OBJ            ObjectType = "STRING"
 	BOOLEAN_OBJ           ObjectType = "BOOLEAN"
 	NULL_OBJ              ObjectType = "NULL"
 	RETURN_VALUE_OBJ      ObjectType = "RETURN_VALUE"
+	BREAK_OBJ             ObjectType = "BREAK"
+	CONTINUE_OBJ          ObjectType = "CONTINUE"
 	ERROR_OBJ             ObjectType = "ERROR"
 	FUNCTION_OBJ          ObjectType = "FUNCTION"
 	BUILTIN_OBJ           ObjectType = "BUILTIN"
 	ARRAY_OBJ             ObjectType = "ARRAY"
 	HASH_OBJ              ObjectType = "HASH"
 	COMPILED_FUNCTION_OBJ ObjectType = "COMPILED_FUNCTION"
+	ITERATOR_OBJ          ObjectType = "ITERATOR"
+	MODULE_OBJ            ObjectType = "MODULE"
+	QUOTE_OBJ             ObjectType = "QUOTE"
+	MACRO_OBJ             ObjectType = "MACRO"
 )
 
 type Integer struct {
@@ -71,6 +147,34 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// BigInteger holds an integer result too large for Integer. Integer
+// operations only produce one when the value does not fit in an int64, so
+// a value always has exactly one representation.
+type BigInteger struct {
+	Value *big.Int
+}
+
+// Type is INTEGER, as for Integer, since which of the two holds a value
+// is not visible to scripts.
+func (bi *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect keeps a fraction or exponent so a whole float still reads as a
+// float, 2.0 rather than 2.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type String struct {
 	Value string
 }
@@ -78,6 +182,18 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return `"` + s.Value + `"` }
 
+// Display is how a value reads when it is put in a string: strings as
+// they are, quoted code as its source, everything else as Inspect shows it.
+func Display(obj Object) string {
+	switch obj := obj.(type) {
+	case *String:
+		return obj.Value
+	case *Quote:
+		return obj.Node.String()
+	}
+	return obj.Inspect()
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -97,26 +213,150 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue carry a loop jump out of the statements of a loop
+// body in the evaluator, the same way ReturnValue carries a return.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// ErrorKind classifies runtime errors. Both the evaluator and the VM raise
+// errors of these kinds, with the same messages.
+type ErrorKind string
+
+const (
+	TypeError          ErrorKind = "TypeError"
+	NameError          ErrorKind = "NameError"
+	IndexError         ErrorKind = "IndexError"
+	ArityError         ErrorKind = "ArityError"
+	ZeroDivisionError  ErrorKind = "ZeroDivisionError"
+	ValueError         ErrorKind = "ValueError"
+	StackOverflowError ErrorKind = "StackOverflowError"
+	ImportError        ErrorKind = "ImportError"
+	MatchError         ErrorKind = "MatchError"
+	// Exception is the kind of the error raised by a throw statement.
+	Exception ErrorKind = "Exception"
+)
+
+// Error is a runtime error. It is a value in the evaluator and is returned
+// as a Go error by the VM.
 type Error struct {
+	Kind    ErrorKind
 	Message string
+	// Pos is where in the source the error was raised, if known.
+	Pos token.Position
+	// Thrown is the value of the throw statement that raised the error,
+	// nil for other errors.
+	Thrown Object
+}
+
+func NewError(kind ErrorKind, format string, a ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// NewThrow raises value as an exception. Throwing the hash a catch clause
+// received for a runtime error raises a runtime error again, at the
+// position of the original one, with the kind and message the hash holds
+// by then. The hash is thrown as is once either is no longer a string.
+func NewThrow(value Object) *Error {
+	if hash, ok := value.(*Hash); ok && hash.caught != nil {
+		kind, kindOK := hash.stringField("kind")
+		message, messageOK := hash.stringField("message")
+		if kindOK && messageOK {
+			return &Error{Kind: ErrorKind(kind), Message: message, Pos: hash.caught.Pos}
+		}
+	}
+	return &Error{Kind: Exception, Message: Display(value), Thrown: value}
+}
+
+// CatchValue is what a catch clause receives for the error: the thrown
+// value, or for a runtime error, a hash with its kind and message.
+func (e *Error) CatchValue() Object {
+	if e.Thrown != nil {
+		return e.Thrown
+	}
+	hash := &Hash{Pairs: make(map[HashKey]HashPair), caught: e}
+	for _, field := range [][2]string{{"kind", string(e.Kind)}, {"message", e.Message}} {
+		key := &String{Value: field[0]}
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: &String{Value: field[1]}}
+	}
+	return hash
+}
+
+// NewInfixError reports operator applied to operands it is not defined
+// for: an unknown operator when they are of the same kind, otherwise a
+// type mismatch.
+func NewInfixError(operator string, left, right Object) *Error {
+	format := "type mismatch: %s %s %s"
+	if left.Type() == right.Type() || isNumber(left) && isNumber(right) {
+		format = "unknown operator: %s %s %s"
+	}
+	return NewError(TypeError, format, left.Type(), operator, right.Type())
+}
+
+// NewArityError reports a call with the wrong number of arguments.
+func NewArityError(want, got int) *Error {
+	return NewError(ArityError, "wrong number of arguments: want=%d, got=%d", want, got)
+}
+
+func isNumber(obj Object) bool {
+	return IsInteger(obj) || obj.Type() == FLOAT_OBJ
+}
+
+func (e *Error) Error() string {
+	return string(e.Kind) + ": " + e.Message
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Error()
+	}
+	return "ERROR: " + e.Error()
+}
 
 type Function struct {
 	Parameters []*ast.Identifier
-	Body       *ast.BlockStatement
-	Env        *Environment
+	// Defaults holds the default values of the parameters, nil for the
+	// ones that have none.
+	Defaults []ast.Expression
+	// Patterns destructures the parameters, nil for the plain ones.
+	Patterns []ast.Pattern
+	Rest     *ast.Identifier
+	Body     *ast.BlockStatement
+	Env      *Environment
+}
+
+func (f *Function) Signature() Signature {
+	sig := Signature{Variadic: f.Rest != nil}
+	for i, param := range f.Parameters {
+		sig.Parameters = append(sig.Parameters, param.Value)
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			sig.NumDefaults++
+		}
+	}
+	return sig
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
 	var out strings.Builder
 	var params []string
-	for _, param := range f.Parameters {
+	for i, param := range f.Parameters {
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			params = append(params, param.String()+" = "+f.Defaults[i].String())
+			continue
+		}
 		params = append(params, param.String())
 	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
 	out.WriteString("fn(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
@@ -125,6 +365,37 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Quote is unevaluated code, as returned by quote.
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string {
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out strings.Builder
+	var params []string
+	for _, param := range m.Parameters {
+		params = append(params, param.String())
+	}
+	out.WriteString("macro(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+	return out.String()
+}
+
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
@@ -139,6 +410,19 @@ type Array struct {
 }
 
 func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+
+// Index returns the element position index refers to, or an error if
+// index is not an integer within the array.
+func (a *Array) Index(index Object) (int, *Error) {
+	if !IsInteger(index) {
+		return 0, NewError(TypeError, "invalid index type: %s", index.Type())
+	}
+	i, ok := index.(*Integer)
+	if !ok || i.Value < 0 || i.Value >= int64(len(a.Elements)) {
+		return 0, NewError(IndexError, "index out of range: %s", index.Inspect())
+	}
+	return int(i.Value), nil
+}
 func (a *Array) Inspect() string {
 	var out strings.Builder
 	var elements []string
@@ -151,16 +435,64 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by the objects that can be used as hash keys.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+// bigIntegerKey is the type of the hash keys of big integers, which are
+// hashed unlike the keys of other integers.
+const bigIntegerKey ObjectType = "BIG_INTEGER"
+
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bigIntegerKey, Value: h.Sum64()}
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
 type Hash struct {
-	Pairs map[string]Object
+	Pairs map[HashKey]HashPair
+	// caught is the runtime error a catch clause received the hash for.
+	caught *Error
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+// Inspect lists the pairs ordered by key, so a hash always reads the same.
 func (h *Hash) Inspect() string {
 	var out strings.Builder
 	var pairs []string
-	for key, value := range h.Pairs {
-		pairs = append(pairs, key+":"+value.Inspect())
+	for _, pair := range h.sortedPairs() {
+		pairs = append(pairs, pair.Key.Inspect()+":"+pair.Value.Inspect())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -168,13 +500,233 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// stringField returns the string value of the hash at the key name.
+func (h *Hash) stringField(name string) (string, bool) {
+	key := &String{Value: name}
+	value, ok := h.Pairs[key.HashKey()].Value.(*String)
+	if !ok {
+		return "", false
+	}
+	return value.Value, true
+}
+
+// sortedPairs returns the pairs of the hash ordered by key, so that they
+// are shown and iterated over the same way every time.
+func (h *Hash) sortedPairs() []HashPair {
+	sorted := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		sorted = append(sorted, pair)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return lessKey(sorted[i].Key, sorted[j].Key)
+	})
+	return sorted
+}
+
+// lessKey orders hash keys: integers by value and ahead of the other keys,
+// which are ordered by how they read.
+func lessKey(a, b Object) bool {
+	if IsInteger(a) && IsInteger(b) {
+		return CompareIntegers(a, b) < 0
+	}
+	if IsInteger(a) != IsInteger(b) {
+		return IsInteger(a)
+	}
+	return a.Inspect() < b.Inspect()
+}
+
+// Module is an imported file. Its exports are indexed by name like a hash.
+type Module struct {
+	Name    string
+	Exports *Hash
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "<module " + m.Name + ">" }
+
+// Export returns the value the module exports under the name index.
+func (m *Module) Export(index Object) (Object, *Error) {
+	name, ok := index.(*String)
+	if !ok {
+		return nil, NewError(TypeError, "invalid index type: %s", index.Type())
+	}
+	pair, ok := m.Exports.Pairs[name.HashKey()]
+	if !ok {
+		return nil, NewError(NameError, "module %s has no export %s", m.Name, name.Value)
+	}
+	return pair.Value, nil
+}
+
+func (m *Module) Iterator() *Iterator {
+	return m.Exports.Iterator()
+}
+
+// Modules caches the modules a program imports by file, and tracks the
+// ones being loaded to catch import cycles.
+type Modules struct {
+	loaded  map[string]*Module
+	loading []string
+}
+
+// Load returns the module for file, calling load to create it the first
+// time file is imported.
+func (m *Modules) Load(file string, load func() Object) Object {
+	if mod, ok := m.loaded[file]; ok {
+		return mod
+	}
+	for i, f := range m.loading {
+		if f == file {
+			cycle := append(m.loading[i:len(m.loading):len(m.loading)], file)
+			return NewError(ImportError, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	m.loading = append(m.loading, file)
+	obj := load()
+	m.loading = m.loading[:len(m.loading)-1]
+	if mod, ok := obj.(*Module); ok {
+		m.loaded[file] = mod
+	}
+	return obj
+}
+
+// Iterable is implemented by the objects a for-in loop can walk over.
+type Iterable interface {
+	Object
+	Iterator() *Iterator
+}
+
+// Iterator yields the elements of an Iterable one at a time. Next returns
+// each element together with its key, the index for arrays and strings,
+// and reports false once there is nothing left.
+type Iterator struct {
+	next func() (Object, Object, bool)
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string {
+	return fmt.Sprintf("Iterator[%p]", it)
+}
+
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	return it.next()
+}
+
+func (a *Array) Iterator() *Iterator {
+	i := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if i >= len(a.Elements) {
+			return nil, nil, false
+		}
+		key := &Integer{Value: int64(i)}
+		i++
+		return key, a.Elements[key.Value], true
+	}}
+}
+
+// Iterator walks a string by character, keyed by character index.
+func (s *String) Iterator() *Iterator {
+	chars := []rune(s.Value)
+	i := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if i >= len(chars) {
+			return nil, nil, false
+		}
+		key := &Integer{Value: int64(i)}
+		i++
+		return key, &String{Value: string(chars[key.Value])}, true
+	}}
+}
+
+// Iterator walks a snapshot of the pairs taken when it is created, in the
+// order Inspect shows them.
+func (h *Hash) Iterator() *Iterator {
+	pairs := h.sortedPairs()
+	i := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if i >= len(pairs) {
+			return nil, nil, false
+		}
+		pair := pairs[i]
+		i++
+		return pair.Key, pair.Value, true
+	}}
+}
+
 type CompiledFunction struct {
-	Instructions  code.Instructions
-	NumLocals     int
+	Instructions code.Instructions
+	NumLocals    int
+	// Locals names the local slots, for errors about them.
+	Locals        []string
 	NumParameters int
+	// Parameters names the parameters, for binding keyword arguments.
+	Parameters []string
+	// NumDefaults is the number of trailing parameters that have a default,
+	// computed by the function's prologue when not passed.
+	NumDefaults int
+	Captures    []Capture
+	// Variadic functions take the arguments after the first NumParameters
+	// as an array, in the local after the parameters.
+	Variadic bool
+	// Name is the name the function was declared with, empty if anonymous.
+	Name string
+	// Positions maps Instructions back to the source, for error reports.
+	Positions code.PosTable
+}
+
+func (cf *CompiledFunction) Signature() Signature {
+	return Signature{Parameters: cf.Parameters, NumDefaults: cf.NumDefaults, Variadic: cf.Variadic}
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
+
+type CaptureScope int
+
+const (
+	CaptureLocal CaptureScope = iota
+	CaptureFree
+)
+
+// Capture tells the VM where a new closure takes one of its free variables
+// from: a local slot of the enclosing frame, or a free variable of the
+// enclosing closure.
+type Capture struct {
+	Scope CaptureScope
+	Index int
+	// Name is the name of the variable, for errors about it.
+	Name string
+}
+
+// FreeVar is a variable captured by a closure. While the frame owning the
+// variable is running it points at that frame's stack slot, so writes on
+// either side are visible to the other; Close moves the value into the
+// FreeVar itself once the frame returns.
+type FreeVar struct {
+	ref   *Object
+	value Object
+}
+
+func NewFreeVar(ref *Object) *FreeVar {
+	return &FreeVar{ref: ref}
+}
+
+func (fv *FreeVar) Get() Object    { return *fv.ref }
+func (fv *FreeVar) Set(val Object) { *fv.ref = val }
+func (fv *FreeVar) Close() {
+	fv.value = *fv.ref
+	fv.ref = &fv.value
+}
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*FreeVar
+}
+
+// Type is FUNCTION, as for the evaluator's functions, since a closure is
+// how the VM represents a function value.
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
@@ -0,0 +1,247 @@
+package object
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestHashKey(t *testing.T) {
+	hello1 := &String{Value: "Hello World"}
+	hello2 := &String{Value: "Hello World"}
+	diff1 := &String{Value: "My name is johnny"}
+	diff2 := &String{Value: "My name is johnny"}
+
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+	if diff1.HashKey() != diff2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+	if hello1.HashKey() == diff1.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+
+	if (&Integer{Value: 1}).HashKey() != (&Integer{Value: 1}).HashKey() {
+		t.Errorf("integers with same value have different hash keys")
+	}
+	if (&Integer{Value: 1}).HashKey() == (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integers with different value have same hash keys")
+	}
+	if (&Boolean{Value: true}).HashKey() == (&Boolean{Value: false}).HashKey() {
+		t.Errorf("true and false have same hash keys")
+	}
+	if (&Integer{Value: 1}).HashKey() == (&Boolean{Value: true}).HashKey() {
+		t.Errorf("1 and true have same hash keys")
+	}
+}
+
+func TestIterators(t *testing.T) {
+	tests := []struct {
+		iterable       Iterable
+		expectedKeys   []string
+		expectedValues []string
+	}{
+		{
+			&Array{Elements: []Object{&Integer{Value: 5}, &String{Value: "a"}}},
+			[]string{"0", "1"},
+			[]string{"5", `"a"`},
+		},
+		{
+			&String{Value: "añb"},
+			[]string{"0", "1", "2"},
+			[]string{`"a"`, `"ñ"`, `"b"`},
+		},
+		{
+			&Array{},
+			nil,
+			nil,
+		},
+		{
+			newTestHash(&String{Value: "b"}, &Integer{Value: 10}, &String{Value: "a"}, &Integer{Value: -1}),
+			[]string{"-1", "10", `"a"`, `"b"`},
+			[]string{"3", "1", "2", "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		var keys, values []string
+		it := tt.iterable.Iterator()
+		for {
+			key, value, ok := it.Next()
+			if !ok {
+				break
+			}
+			keys = append(keys, key.Inspect())
+			values = append(values, value.Inspect())
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.expectedKeys, ",") {
+			t.Errorf("wrong keys. want=%v, got=%v", tt.expectedKeys, keys)
+		}
+		if strings.Join(values, ",") != strings.Join(tt.expectedValues, ",") {
+			t.Errorf("wrong values. want=%v, got=%v", tt.expectedValues, values)
+		}
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	one, two := &String{Value: "one"}, &String{Value: "two"}
+	hash.Pairs[one.HashKey()] = HashPair{Key: one, Value: &Integer{Value: 1}}
+	hash.Pairs[two.HashKey()] = HashPair{Key: two, Value: &Integer{Value: 2}}
+
+	seen := map[string]string{}
+	it := hash.Iterator()
+	for {
+		key, value, ok := it.Next()
+		if !ok {
+			break
+		}
+		seen[key.Inspect()] = value.Inspect()
+	}
+	if len(seen) != 2 || seen[`"one"`] != "1" || seen[`"two"`] != "2" {
+		t.Errorf("wrong hash iteration. got=%v", seen)
+	}
+}
+
+// newTestHash returns a hash of keys, each mapped to its index in keys.
+func newTestHash(keys ...Hashable) *Hash {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for i, key := range keys {
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: &Integer{Value: int64(i)}}
+	}
+	return hash
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+		{1.25, "1.25"},
+	}
+
+	for _, tt := range tests {
+		got := (&Float{Value: tt.value}).Inspect()
+		if got != tt.want {
+			t.Errorf("wrong Inspect for %v. want=%q, got=%q", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestNewIntegerNormalizes(t *testing.T) {
+	small := NewInteger(big.NewInt(42))
+	if i, ok := small.(*Integer); !ok || i.Value != 42 {
+		t.Errorf("NewInteger(42) should be Integer 42, got %T (%+v)", small, small)
+	}
+
+	huge := new(big.Int).Lsh(big.NewInt(1), 64)
+	if _, ok := NewInteger(huge).(*BigInteger); !ok {
+		t.Errorf("NewInteger(2^64) should be BigInteger")
+	}
+
+	one := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	two := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	if one.HashKey() != two.HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+}
+
+func TestSignatureBind(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	sig := Signature{Parameters: []string{"a", "b", "c"}, NumDefaults: 1, Variadic: true}
+	tests := []struct {
+		args     []Object
+		keywords []string
+		want     string
+	}{
+		{[]Object{one, two}, nil, "[1 2 _ []]"},
+		{[]Object{one, two, one, two}, nil, "[1 2 1 [2]]"},
+		{[]Object{one, two, one}, []string{"b", "c"}, "[1 2 1 []]"},
+		{[]Object{one}, nil, "ArityError: missing argument for parameter b"},
+		{[]Object{one}, []string{"c"}, "ArityError: missing arguments for parameters a, b"},
+		{[]Object{one, two}, []string{"a"}, "ArityError: multiple values for parameter a"},
+		{[]Object{one, two, one}, []string{"rest"}, "ArityError: unknown keyword argument: rest"},
+		{[]Object{nil, nil}, nil, "[<nil> <nil> _ []]"},
+		{[]Object{one, nil}, []string{"b"}, "[1 <nil> _ []]"},
+		{[]Object{nil, two, nil}, []string{"a"}, "ArityError: multiple values for parameter a"},
+	}
+	for _, tt := range tests {
+		bound, passed, err := sig.Bind(tt.args, tt.keywords)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			var parts []string
+			for i, obj := range bound {
+				if i < len(passed) && !passed[i] {
+					parts = append(parts, "_")
+				} else if obj == nil {
+					parts = append(parts, "<nil>")
+				} else {
+					parts = append(parts, obj.Inspect())
+				}
+			}
+			got = "[" + strings.Join(parts, " ") + "]"
+		}
+		if got != tt.want {
+			t.Errorf("Bind(%d args, %q) want %s, but got %s", len(tt.args), tt.keywords, tt.want, got)
+		}
+	}
+
+	_, _, err := Signature{Parameters: []string{"a"}}.Bind([]Object{one, two}, nil)
+	if err == nil || err.Error() != "ArityError: wrong number of arguments: want=1, got=2" {
+		t.Errorf("wrong error for too many arguments: %v", err)
+	}
+}
+
+func TestDestructure(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	arr := &Array{Elements: []Object{one, two}}
+
+	values, err := DestructureArray(arr, 1, true)
+	if err != nil || len(values) != 2 || values[0] != one || values[1].Inspect() != "[2]" {
+		t.Fatalf("DestructureArray with rest got %v, %v", values, err)
+	}
+	values[1].(*Array).Elements[0] = one
+	if arr.Elements[1] != two {
+		t.Fatalf("rest array shares elements with the destructured array")
+	}
+	_, err = DestructureArray(arr, 3, false)
+	if err == nil || err.Error() != "ValueError: cannot destructure array of length 2 into 3 elements" {
+		t.Fatalf("wrong error: %v", err)
+	}
+	_, err = DestructureArray(arr, 1, false)
+	if err == nil || err.Error() != "ValueError: cannot destructure array of length 2 into 1 element" {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	key := &String{Value: "a"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: one}}}
+	values, err = DestructureHash(hash, []string{"a"})
+	if err != nil || len(values) != 1 || values[0] != one {
+		t.Fatalf("DestructureHash got %v, %v", values, err)
+	}
+	_, err = DestructureHash(&Module{Name: "m", Exports: hash}, []string{"b"})
+	if err == nil || err.Error() != "NameError: module m has no export b" {
+		t.Fatalf("wrong error: %v", err)
+	}
+	_, err = DestructureHash(arr, nil)
+	if err == nil || err.Error() != "TypeError: cannot destructure ARRAY as a hash" {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestHashInspect(t *testing.T) {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for _, key := range []Hashable{&String{Value: "b"}, &Integer{Value: 10}, &String{Value: "a"}, &Integer{Value: -1}, &Boolean{Value: true}} {
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: &Integer{Value: 0}}
+	}
+	want := `{-1:0, 10:0, "a":0, "b":0, true:0}`
+	for i := 0; i < 10; i++ {
+		if got := hash.Inspect(); got != want {
+			t.Fatalf("Inspect want %s, but got %s", want, got)
+		}
+	}
+}
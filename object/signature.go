@@ -0,0 +1,77 @@
+package object
+
+import "strings"
+
+// Signature describes the parameters of a function, for binding the
+// arguments of a call to them.
+type Signature struct {
+	Parameters []string
+	// NumDefaults is the number of trailing parameters that have a default.
+	NumDefaults int
+	// Variadic functions take the extra positional arguments as an array.
+	Variadic bool
+}
+
+// Bind matches the arguments of a call to the parameters. args holds the
+// positional arguments followed by the values of the keyword arguments
+// named by keywords. The result has one entry per parameter, nil for the
+// ones left to their default, followed by the rest array if the function
+// is variadic. passed reports for each parameter whether an argument was
+// bound to it.
+func (s Signature) Bind(args []Object, keywords []string) (bound []Object, passed []bool, err *Error) {
+	numParams := len(s.Parameters)
+	numPositional := len(args) - len(keywords)
+	if !s.Variadic && numPositional > numParams {
+		return nil, nil, NewArityError(numParams, numPositional)
+	}
+
+	bound = make([]Object, numParams, numParams+1)
+	passed = make([]bool, numParams)
+	for i := range args[:min(numPositional, numParams)] {
+		bound[i] = args[i]
+		passed[i] = true
+	}
+	for i, name := range keywords {
+		j := s.index(name)
+		if j < 0 {
+			return nil, nil, NewError(ArityError, "unknown keyword argument: %s", name)
+		}
+		if passed[j] {
+			return nil, nil, NewError(ArityError, "multiple values for parameter %s", name)
+		}
+		bound[j] = args[numPositional+i]
+		passed[j] = true
+	}
+
+	var missing []string
+	for i, name := range s.Parameters[:numParams-s.NumDefaults] {
+		if !passed[i] {
+			missing = append(missing, name)
+		}
+	}
+	switch len(missing) {
+	case 0:
+	case 1:
+		return nil, nil, NewError(ArityError, "missing argument for parameter %s", missing[0])
+	default:
+		return nil, nil, NewError(ArityError, "missing arguments for parameters %s", strings.Join(missing, ", "))
+	}
+
+	if s.Variadic {
+		rest := []Object{}
+		if numPositional > numParams {
+			rest = append(rest, args[numParams:numPositional]...)
+		}
+		bound = append(bound, &Array{Elements: rest})
+	}
+	return bound, passed, nil
+}
+
+func (s Signature) index(name string) int {
+	for i, param := range s.Parameters {
+		if param == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -1,8 +1,13 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/lqqyt2423/go-monkey/ast"
 	"github.com/lqqyt2423/go-monkey/lexer"
@@ -18,6 +23,13 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// loopDepth counts the loops enclosing the current token within the
+	// current function body, so break and continue can be checked.
+	loopDepth int
+	// blockDepth counts the blocks enclosing the current token, so exports
+	// can be kept to the top level.
+	blockDepth int
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -27,27 +39,46 @@ func New(l *lexer.Lexer) *Parser {
 		infixParseFns:  make(map[token.TokenType]infixParseFn),
 	}
 
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.INTERP_START, p.parseInterpolatedString)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -65,24 +96,38 @@ type Precedence int
 
 const (
 	LOWEST      Precedence = iota + 1
+	ASSIGN                 // =
+	LOGICAL_OR             // ||
+	LOGICAL_AND            // &&
 	EQUALS                 // ==
-	LESSGREATER            // > or <
-	SUM                    // +
-	PRODUCT                // *
-	PREFIX                 // -X or !X
+	LESSGREATER            // > < >= <=
+	SUM                    // + - | ^
+	PRODUCT                // * / % << >> &
+	PREFIX                 // -X !X ~X
 	CALL                   // myFunction(X)
 	INDEX                  // array[index]
 )
 
 var precedences = map[token.TokenType]Precedence{
+	token.ASSIGN:   ASSIGN,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.ASTERISK: PRODUCT,
 	token.SLASH:    PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.SHL:      PRODUCT,
+	token.SHR:      PRODUCT,
+	token.BIT_AND:  PRODUCT,
+	token.BIT_OR:   SUM,
+	token.CARET:    SUM,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
+	token.AND:      LOGICAL_AND,
+	token.OR:       LOGICAL_OR,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
@@ -103,12 +148,44 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ParseFile reads and parses the program in filename. Parse errors are
+// returned together, one per line.
+func ParseFile(filename string) (*ast.Program, error) {
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := New(lexer.NewFile(filename, string(input)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+	return program, nil
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.IMPORT:
+		if p.peekTokenIs(token.STRING) {
+			return p.parseImportStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -118,24 +195,77 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{
 		Token: p.curToken,
 	}
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
-	stmt.Name = &ast.Identifier{
-		Token: p.curToken,
-		Value: p.curToken.Literal,
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern(false)
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		}
 	}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fn.Name = stmt.Name.Value
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
 }
 
+func (p *Parser) parseExportStatement() ast.Statement {
+	if p.blockDepth > 0 {
+		p.errorf(p.curToken.Pos, "export outside top level")
+	}
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+	stmt := p.parseLetStatement()
+	if stmt == nil {
+		return nil
+	}
+	stmt.Exported = true
+	return stmt
+}
+
+func (p *Parser) parseImportStatement() ast.Statement {
+	stmt := &ast.ImportStatement{
+		Token: p.curToken,
+	}
+	stmt.Import = p.parseImportExpression().(*ast.ImportExpression)
+	name := strings.TrimSuffix(filepath.Base(stmt.Import.Path), filepath.Ext(stmt.Import.Path))
+	if !isIdentifier(name) {
+		p.errorf(stmt.Token.Pos, "cannot name module %q after its file, bind it with let", stmt.Import.Path)
+		return nil
+	}
+	stmt.Name = &ast.Identifier{
+		Token: token.Token{Type: token.IDENT, Literal: name, Pos: stmt.Token.Pos},
+		Value: name,
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// isIdentifier reports whether name would be lexed as an identifier.
+func isIdentifier(name string) bool {
+	l := lexer.New(name)
+	tok := l.NextToken()
+	return tok.Type == token.IDENT && tok.Literal == name
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{
 		Token: p.curToken,
@@ -148,6 +278,103 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{
+		Token: p.curToken,
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{
+		Token: p.curToken,
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Value = p.parseIdentifier().(*ast.Identifier)
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = p.parseIdentifier().(*ast.Identifier)
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseBreakStatement() ast.Statement {
+	stmt := &ast.BreakStatement{
+		Token: p.curToken,
+	}
+	if p.loopDepth == 0 {
+		p.errorf(p.curToken.Pos, "break outside loop")
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() ast.Statement {
+	stmt := &ast.ContinueStatement{
+		Token: p.curToken,
+	}
+	if p.loopDepth == 0 {
+		p.errorf(p.curToken.Pos, "continue outside loop")
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{
+		Token: p.curToken,
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{
 		Token: p.curToken,
@@ -163,6 +390,8 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	stmt := &ast.BlockStatement{
 		Token: p.curToken,
 	}
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
 	p.nextToken()
 	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
 		s := p.parseStatement()
@@ -177,7 +406,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 func (p *Parser) parseExpression(precedence Precedence) ast.Expression {
 	prefixFn, ok := p.prefixParseFns[p.curToken.Type]
 	if !ok {
-		p.errors = append(p.errors, fmt.Sprintf("no prefix fn found %s", p.curToken.Type))
+		p.errorf(p.curToken.Pos, "no prefix fn found %s", p.curToken.Type)
 		return nil
 	}
 	exp := prefixFn()
@@ -201,18 +430,83 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	}
 }
 
+// parseIllegal reports the lexer's description of an ILLEGAL token.
+func (p *Parser) parseIllegal() ast.Expression {
+	p.errorf(p.curToken.Pos, "%s", p.curToken.Literal)
+	return nil
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
+	lit := &ast.IntegerLiteral{Token: p.curToken}
 	v, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if err == nil {
+		lit.Value = v
+		return lit
+	}
+	bigValue, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+	if !ok {
+		p.errorf(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+	lit.Big = bigValue
+	return lit
+}
+
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+	for {
+		if p.curToken.Literal != "" {
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if p.curTokenIs(token.INTERP_END) {
+			return str
+		}
+		p.nextToken()
+		exp := p.parseExpression(LOWEST)
+		if exp == nil {
+			return nil
+		}
+		str.Parts = append(str.Parts, exp)
+		if p.peekTokenIs(token.INTERP_MID) {
+			p.nextToken()
+		} else if !p.expectPeek(token.INTERP_END) {
+			return nil
+		}
+	}
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	v, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.errors = append(p.errors, fmt.Sprintf("could not parse %q as integer", p.curToken.Literal))
+		p.errorf(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
-	return &ast.IntegerLiteral{
+	return &ast.FloatLiteral{
 		Token: p.curToken,
 		Value: v,
 	}
 }
 
+// parseImportExpression parses import "path" and import("path"). The path
+// has to be a plain string, so modules can be found before running.
+func (p *Parser) parseImportExpression() ast.Expression {
+	exp := &ast.ImportExpression{
+		Token: p.curToken,
+	}
+	paren := p.peekTokenIs(token.LPAREN)
+	if paren {
+		p.nextToken()
+	}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	exp.Path = p.curToken.Literal
+	if paren && !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return exp
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{
 		Token: p.curToken,
@@ -249,6 +543,25 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil
+	default:
+		p.errorf(target.Pos(), "invalid assignment target: %s", target.String())
+		return nil
+	}
+	exp := &ast.AssignExpression{
+		Token:  p.curToken,
+		Target: target,
+	}
+	p.nextToken()
+	// one below ASSIGN so that a = b = c groups as a = (b = c)
+	exp.Value = p.parseExpression(ASSIGN - 1)
+	return exp
+}
+
 func (p *Parser) parseGroupExpression() ast.Expression {
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
@@ -282,6 +595,47 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	exp := &ast.TryExpression{
+		Token: p.curToken,
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	exp.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			exp.CatchParam = p.parseIdentifier().(*ast.Identifier)
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Catch = p.parseBlockStatement()
+	}
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Finally = p.parseBlockStatement()
+	}
+	if exp.Catch == nil && exp.Finally == nil {
+		p.errorf(exp.Token.Pos, "try without catch or finally")
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	exp := &ast.FunctionLiteral{
 		Token: p.curToken,
@@ -289,46 +643,330 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	if !p.peekTokenIs(token.RPAREN) {
-		if !p.expectPeek(token.IDENT) {
+	if !p.parseFunctionParameters(exp) {
+		return nil
+	}
+	exp.Body = p.parseFunctionBody()
+	if exp.Body == nil {
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	exp := &ast.MacroLiteral{
+		Token: p.curToken,
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	var fl ast.FunctionLiteral
+	if !p.parseFunctionParameters(&fl) {
+		return nil
+	}
+	if fl.Rest != nil {
+		p.errorf(fl.Rest.Pos(), "macro cannot have a rest parameter")
+		return nil
+	}
+	for i, param := range fl.Parameters {
+		if fl.Patterns[i] != nil {
+			p.errorf(param.Pos(), "macro parameter %s cannot be a pattern", param.Value)
 			return nil
 		}
-		param := p.parseIdentifier().(*ast.Identifier)
-		exp.Parameters = append(exp.Parameters, param)
-		for p.peekTokenIs(token.COMMA) {
+		if fl.Defaults[i] != nil {
+			p.errorf(param.Pos(), "macro parameter %s cannot have a default", param.Value)
+			return nil
+		}
+	}
+	exp.Parameters = fl.Parameters
+	exp.Body = p.parseFunctionBody()
+	if exp.Body == nil {
+		return nil
+	}
+	return exp
+}
+
+// parseFunctionParameters parses the parameter list after the current (
+// token into fl: parameters or patterns with an optional = default, which
+// once given all the following parameters need, and a last optional
+// ...rest parameter.
+func (p *Parser) parseFunctionParameters(fl *ast.FunctionLiteral) bool {
+	fl.Parameters = []*ast.Identifier{}
+	fl.Defaults = []ast.Expression{}
+	fl.Patterns = []ast.Pattern{}
+	for !p.peekTokenIs(token.RPAREN) {
+		if len(fl.Parameters) > 0 && !p.expectPeek(token.COMMA) {
+			return false
+		}
+		if p.peekTokenIs(token.ELLIPSIS) {
 			p.nextToken()
 			if !p.expectPeek(token.IDENT) {
-				return nil
+				return false
 			}
-			param := p.parseIdentifier().(*ast.Identifier)
-			exp.Parameters = append(exp.Parameters, param)
+			fl.Rest = p.parseIdentifier().(*ast.Identifier)
+			break
 		}
+		var param *ast.Identifier
+		var pattern ast.Pattern
+		if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+			p.nextToken()
+			pattern = p.parsePattern(false)
+			if pattern == nil {
+				return false
+			}
+			param = &ast.Identifier{
+				Token: token.Token{Type: token.IDENT, Literal: pattern.String(), Pos: pattern.Pos()},
+				Value: pattern.String(),
+			}
+		} else {
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			param = p.parseIdentifier().(*ast.Identifier)
+		}
+		var def ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			def = p.parseExpression(ASSIGN)
+		} else if len(fl.Defaults) > 0 && fl.Defaults[len(fl.Defaults)-1] != nil {
+			p.errorf(param.Pos(), "parameter %s without default follows parameter with default", param.Value)
+			return false
+		}
+		fl.Parameters = append(fl.Parameters, param)
+		fl.Defaults = append(fl.Defaults, def)
+		fl.Patterns = append(fl.Patterns, pattern)
 	}
 	if !p.expectPeek(token.RPAREN) {
+		return false
+	}
+	return p.checkParameterNames(fl)
+}
+
+// checkParameterNames reports a name bound by more than one parameter.
+func (p *Parser) checkParameterNames(fl *ast.FunctionLiteral) bool {
+	var names []*ast.Identifier
+	for i, param := range fl.Parameters {
+		if fl.Patterns[i] != nil {
+			names = append(names, ast.PatternNames(fl.Patterns[i])...)
+		} else {
+			names = append(names, param)
+		}
+	}
+	if fl.Rest != nil {
+		names = append(names, fl.Rest)
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name.Value] {
+			p.errorf(name.Pos(), "parameter %s repeated", name.Value)
+			return false
+		}
+		seen[name.Value] = true
+	}
+	return true
+}
+
+// parsePattern parses the destructuring pattern at the current token: an
+// identifier, _, [elements, ...rest] or {key, key: pattern}. The patterns
+// of a match expression may also be literals.
+func (p *Parser) parsePattern(literals bool) ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return p.parseIdentifier().(*ast.Identifier)
+	case token.LBRACKET:
+		return p.parseArrayPattern(literals)
+	case token.LBRACE:
+		return p.parseHashPattern(literals)
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		if literals {
+			return &ast.LiteralPattern{Value: p.prefixParseFns[p.curToken.Type]()}
+		}
+	case token.MINUS:
+		if literals && (p.peekTokenIs(token.INT) || p.peekTokenIs(token.FLOAT)) {
+			return &ast.LiteralPattern{Value: p.parsePrefixExpression()}
+		}
+	}
+	p.errorf(p.curToken.Pos, "expect pattern, but got %s", p.curToken.Type)
+	return nil
+}
+
+func (p *Parser) parseArrayPattern(literals bool) ast.Pattern {
+	pattern := &ast.ArrayPattern{
+		Token: p.curToken,
+	}
+	for !p.peekTokenIs(token.RBRACKET) {
+		if (len(pattern.Elements) > 0 || pattern.Rest != nil) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = p.parseIdentifier().(*ast.Identifier)
+			break
+		}
+		el := p.parsePattern(literals)
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+	}
+	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	return pattern
+}
+
+func (p *Parser) parseHashPattern(literals bool) ast.Pattern {
+	pattern := &ast.HashPattern{
+		Token: p.curToken,
+	}
+	for !p.peekTokenIs(token.RBRACE) {
+		if len(pattern.Keys) > 0 && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+		var key ast.Expression
+		var target ast.Pattern
+		if p.peekTokenIs(token.STRING) {
+			p.nextToken()
+			key = p.parseStringLiteral()
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+			target = p.parsePattern(literals)
+		} else {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			ident := p.parseIdentifier().(*ast.Identifier)
+			key, target = ident, ident
+			if p.peekTokenIs(token.COLON) {
+				p.nextToken()
+				p.nextToken()
+				target = p.parsePattern(literals)
+			}
+		}
+		if target == nil {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Targets = append(pattern.Targets, target)
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return pattern
+}
+
+// parseMatchExpression parses match (subject) { pattern if guard => body, ... },
+// where a body is an expression or a block.
+func (p *Parser) parseMatchExpression() ast.Expression {
+	exp := &ast.MatchExpression{
+		Token: p.curToken,
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	exp.Subject = p.parseExpression(LOWEST)
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	exp.Body = p.parseBlockStatement()
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		exp.Arms = append(exp.Arms, arm)
+		// a block body needs no comma after it
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RBRACE) && !p.curTokenIs(token.RBRACE) {
+			p.peekError(token.COMMA)
+			return nil
+		}
+	}
+	p.nextToken()
+	if len(exp.Arms) == 0 {
+		p.errorf(exp.Token.Pos, "match without arms")
+		return nil
+	}
 	return exp
 }
 
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{
+		Pattern: p.parsePattern(true),
+	}
+	if arm.Pattern == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		arm.Body = p.parseBlockStatement()
+		return arm
+	}
+	p.nextToken()
+	stmt := &ast.ExpressionStatement{
+		Token:      p.curToken,
+		Expression: p.parseExpression(LOWEST),
+	}
+	arm.Body = &ast.BlockStatement{
+		Token:      stmt.Token,
+		Statements: []ast.Statement{stmt},
+	}
+	return arm
+}
+
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	// loops outside the function can't be broken from inside it
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	body := p.parseBlockStatement()
+	p.loopDepth = loopDepth
+	return body
+}
+
 func (p *Parser) parseCallExpression(left ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{
 		Token:    p.curToken,
 		Function: left,
 	}
-	if !p.peekTokenIs(token.RPAREN) {
+	for !p.peekTokenIs(token.RPAREN) {
+		if len(exp.Arguments)+len(exp.Keywords) > 0 && !p.expectPeek(token.COMMA) {
+			return nil
+		}
 		p.nextToken()
-		argExp := p.parseExpression(LOWEST)
-		exp.Arguments = append(exp.Arguments, argExp)
-		for p.peekTokenIs(token.COMMA) {
-			p.nextToken()
-			p.nextToken()
-			argExp := p.parseExpression(LOWEST)
-			exp.Arguments = append(exp.Arguments, argExp)
+		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.COLON) {
+			kw := p.parseKeywordArgument(exp.Keywords)
+			if kw == nil {
+				return nil
+			}
+			exp.Keywords = append(exp.Keywords, kw)
+			continue
 		}
+		if len(exp.Keywords) > 0 {
+			p.errorf(p.curToken.Pos, "positional argument follows keyword argument")
+			return nil
+		}
+		exp.Arguments = append(exp.Arguments, p.parseExpression(LOWEST))
 	}
 	if !p.expectPeek(token.RPAREN) {
 		return nil
@@ -336,6 +974,24 @@ func (p *Parser) parseCallExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseKeywordArgument parses name: value, checking that name is not
+// passed twice.
+func (p *Parser) parseKeywordArgument(seen []*ast.KeywordArgument) *ast.KeywordArgument {
+	kw := &ast.KeywordArgument{
+		Name: p.parseIdentifier().(*ast.Identifier),
+	}
+	for _, other := range seen {
+		if other.Name.Value == kw.Name.Value {
+			p.errorf(kw.Name.Pos(), "keyword argument %s repeated", kw.Name.Value)
+			return nil
+		}
+	}
+	p.nextToken()
+	p.nextToken()
+	kw.Value = p.parseExpression(LOWEST)
+	return kw
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	exp := &ast.ArrayLiteral{Token: p.curToken}
 	if !p.peekTokenIs(token.RBRACKET) {
@@ -418,9 +1074,18 @@ func (p *Parser) expectPeek(typ token.TokenType) bool {
 	}
 }
 
+// errorf records an error found at pos.
+func (p *Parser) errorf(pos token.Position, format string, args ...interface{}) {
+	p.errors = append(p.errors, pos.String()+": "+fmt.Sprintf(format, args...))
+}
+
 func (p *Parser) peekError(typ token.TokenType) {
-	msg := fmt.Sprintf("expect next token to be %s, but got %s", typ, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.peekTokenIs(token.ILLEGAL) {
+		// what the lexer choked on says more than the token it was expecting
+		p.errorf(p.peekToken.Pos, "%s", p.peekToken.Literal)
+		return
+	}
+	p.errorf(p.peekToken.Pos, "expect next token to be %s, but got %s", typ, p.peekToken.Type)
 }
 
 func (p *Parser) nextToken() {
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/lqqyt2423/go-monkey/ast"
@@ -153,6 +154,48 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	if exp.TokenLiteral() != "5" {
 		t.Fatalf("want 5, but got %s", exp.TokenLiteral())
 	}
+
+	p = New(lexer.New("18446744073709551616;"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	exp = program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if exp.Big == nil || exp.Big.String() != "18446744073709551616" {
+		t.Fatalf("want big value 18446744073709551616, but got %v", exp.Big)
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantValue float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E3;", 2500},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if len(program.Statements) != 1 {
+				t.Fatalf("program.Statements len want %d, but got %d", 1, len(program.Statements))
+			}
+			exStmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+			if !ok {
+				t.Fatalf("should be *ast.ExpressionStatement, but got %T", program.Statements[0])
+			}
+			exp, ok := exStmt.Expression.(*ast.FloatLiteral)
+			if !ok {
+				t.Fatalf("should be *ast.FloatLiteral, but got %T", exStmt.Expression)
+			}
+			if exp.Value != tt.wantValue {
+				t.Fatalf("want %g, but got %g", tt.wantValue, exp.Value)
+			}
+		})
+	}
 }
 
 func TestPrefixExpression(t *testing.T) {
@@ -303,6 +346,273 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{"while (x < 10) { x }", "while (x < 10) x"},
+		{"while (true) { break; }", "while true break;"},
+		{"while (true) { continue }", "while true continue;"},
+		{"while (a) { while (b) { break; } continue; }", "while a while b break;continue;"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if len(program.Statements) != 1 {
+				t.Fatalf("program.Statements len should be 1, but got %d", len(program.Statements))
+			}
+			stmt, ok := program.Statements[0].(*ast.WhileStatement)
+			if !ok {
+				t.Fatalf("should be *ast.WhileStatement, but got %T", program.Statements[0])
+			}
+			if stmt.String() != tt.wantStr {
+				t.Fatalf("stmt.String() want %q, but got %q", tt.wantStr, stmt.String())
+			}
+		})
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantKey   string
+		wantValue string
+		wantStr   string
+	}{
+		{"for (x in arr) { x }", "", "x", "for (x in arr) x"},
+		{"for (i, x in [1, 2]) { break; }", "i", "x", "for (i, x in [1, 2]) break;"},
+		{`for (k, v in {"a": 1}) { continue; };`, "k", "v", `for (k, v in {"a":1}) continue;`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if len(program.Statements) != 1 {
+				t.Fatalf("program.Statements len should be 1, but got %d", len(program.Statements))
+			}
+			stmt, ok := program.Statements[0].(*ast.ForStatement)
+			if !ok {
+				t.Fatalf("should be *ast.ForStatement, but got %T", program.Statements[0])
+			}
+			if tt.wantKey == "" && stmt.Key != nil {
+				t.Fatalf("stmt.Key should be nil, but got %q", stmt.Key.Value)
+			}
+			if tt.wantKey != "" && (stmt.Key == nil || stmt.Key.Value != tt.wantKey) {
+				t.Fatalf("stmt.Key want %q, but got %v", tt.wantKey, stmt.Key)
+			}
+			if stmt.Value.Value != tt.wantValue {
+				t.Fatalf("stmt.Value want %q, but got %q", tt.wantValue, stmt.Value.Value)
+			}
+			if stmt.String() != tt.wantStr {
+				t.Fatalf("stmt.String() want %q, but got %q", tt.wantStr, stmt.String())
+			}
+		})
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{"try { x } catch (e) { e }", "try x catch (e) e"},
+		{"try { x } catch { 1 }", "try x catch 1"},
+		{"try { x } finally { y }", "try x finally y"},
+		{"let a = try { f } catch (e) { 0 } finally { g };", "let a = try f catch (e) 0 finally g;"},
+		{`throw "bad";`, `throw "bad";`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if program.String() != tt.wantStr {
+				t.Fatalf("program.String() want %q, but got %q", tt.wantStr, program.String())
+			}
+		})
+	}
+
+	p := New(lexer.New("x;\ntry { x }"))
+	p.ParseProgram()
+	want := "2:1: try without catch or finally"
+	if len(p.Errors()) == 0 || p.Errors()[0] != want {
+		t.Fatalf("parser errors want %q first, but got %q", want, p.Errors())
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{`import "lib/strings.mk"`, `import "lib/strings.mk";`},
+		{`let s = import("lib/strings.mk");`, `let s = import("lib/strings.mk");`},
+		{`import("a.mk")["x"];`, `(import("a.mk")["x"])`},
+		{"export let x = 1;", "export let x = 1;"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if program.String() != tt.wantStr {
+				t.Fatalf("program.String() want %q, but got %q", tt.wantStr, program.String())
+			}
+		})
+	}
+
+	p := New(lexer.New(`import "lib/my_strings.mk";`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ImportStatement)
+	if stmt.Name.Value != "my_strings" {
+		t.Fatalf("import name want %q, but got %q", "my_strings", stmt.Name.Value)
+	}
+
+	errTests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"if (true) { export let x = 1; }", "1:13: export outside top level"},
+		{`import "lib/my-strings.mk";`, `1:1: cannot name module "lib/my-strings.mk" after its file, bind it with let`},
+		{`import "if.mk";`, `1:1: cannot name module "if.mk" after its file, bind it with let`},
+		{"import(x)", "1:8: expect next token to be STRING, but got IDENT"},
+	}
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.wantErr {
+			t.Fatalf("%q: parser errors want %q first, but got %q", tt.input, tt.wantErr, p.Errors())
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"continue;", "1:1: continue outside loop"},
+		{"if (true) { break; }", "1:13: break outside loop"},
+		{"while (true) { fn() { continue; } }", "1:23: continue outside loop"},
+		{"for (x in xs) { fn() { break; } }", "1:24: break outside loop"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+			if len(p.Errors()) != 1 {
+				t.Fatalf("parser errors len want 1, but got %d: %q", len(p.Errors()), p.Errors())
+			}
+			if p.Errors()[0] != tt.wantErr {
+				t.Fatalf("parser error want %q, but got %q", tt.wantErr, p.Errors()[0])
+			}
+		})
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"1 = 2", "1:1: invalid assignment target: 1"},
+		{"a + b = c", "1:3: invalid assignment target: (a + b)"},
+		{"-a = 1", "1:1: invalid assignment target: (-a)"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+			if len(p.Errors()) != 1 {
+				t.Fatalf("parser errors len want 1, but got %d: %q", len(p.Errors()), p.Errors())
+			}
+			if p.Errors()[0] != tt.wantErr {
+				t.Fatalf("parser error want %q, but got %q", tt.wantErr, p.Errors()[0])
+			}
+		})
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantParts int
+		wantStr   string
+	}{
+		{`"hello ${name}"`, 2, `"hello ${name}"`},
+		{`"${a + 1} and ${b[0]}!"`, 4, `"${(a + 1)} and ${(b[0])}!"`},
+		{`"${x}"`, 1, `"${x}"`},
+		{`"<${ "${y}" }>"`, 3, `"<${"${y}"}>"`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			stmt := program.Statements[0].(*ast.ExpressionStatement)
+			str, ok := stmt.Expression.(*ast.InterpolatedString)
+			if !ok {
+				t.Fatalf("should be *ast.InterpolatedString, but got %T", stmt.Expression)
+			}
+			if len(str.Parts) != tt.wantParts {
+				t.Fatalf("parts len want %d, but got %d", tt.wantParts, len(str.Parts))
+			}
+			if str.String() != tt.wantStr {
+				t.Fatalf("str.String() want %q, but got %q", tt.wantStr, str.String())
+			}
+		})
+	}
+}
+
+func TestIllegalTokens(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{`let s = "abc`, "1:9: unterminated string literal"},
+		{"let s = `abc", "1:9: unterminated raw string literal"},
+		{`puts("a\qb")`, `1:6: invalid escape sequence \q in string literal`},
+		{"1 + @", "1:5: illegal character '@'"},
+		{`"a ${x"`, "1:7: unterminated string literal"},
+		{`"a ${}"`, "1:6: no prefix fn found INTERP_END"},
+		{"let x = 1; /* a /* b */", "1:12: unterminated block comment"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+			if len(p.Errors()) == 0 {
+				t.Fatalf("expected parser errors")
+			}
+			if p.Errors()[0] != tt.wantErr {
+				t.Fatalf("parser error want %q, but got %q", tt.wantErr, p.Errors()[0])
+			}
+		})
+	}
+}
+
 func TestFunctionLiteral(t *testing.T) {
 	tests := []struct {
 		input string
@@ -337,6 +647,237 @@ func TestFunctionLiteral(t *testing.T) {
 	}
 }
 
+func TestRestParameters(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{"fn(...args) { args }", "fn(...args) args"},
+		{"fn(a, b, ...rest) { rest }", "fn(a, b, ...rest) rest"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if program.String() != tt.wantStr {
+				t.Fatalf("program.String() want %q, but got %q", tt.wantStr, program.String())
+			}
+		})
+	}
+
+	errTests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"fn(...rest, a) { a }", "1:11: expect next token to be ), but got ,"},
+		{"fn(...) { 1 }", "1:7: expect next token to be IDENT, but got )"},
+		{"macro(...rest) { rest }", "1:10: macro cannot have a rest parameter"},
+	}
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.wantErr {
+			t.Fatalf("%q: parser errors want %q first, but got %q", tt.input, tt.wantErr, p.Errors())
+		}
+	}
+}
+
+func TestDefaultsAndKeywordArguments(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{`fn(path, mode = "r") { mode }`, `fn(path, mode = "r") mode`},
+		{"fn(a, b = a * 2, ...rest) { b }", "fn(a, b = (a * 2), ...rest) b"},
+		{`open(path, mode: "w")`, `open(path, mode: "w") `},
+		{"f(x: 1, y: a + b)", "f(x: 1, y: (a + b)) "},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if program.String() != tt.wantStr {
+				t.Fatalf("program.String() want %q, but got %q", tt.wantStr, program.String())
+			}
+		})
+	}
+
+	errTests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"fn(a = 1, b) { b }", "1:11: parameter b without default follows parameter with default"},
+		{"macro(a = 1) { a }", "1:7: macro parameter a cannot have a default"},
+		{"f(x: 1, 2)", "1:9: positional argument follows keyword argument"},
+		{"f(x: 1, x: 2)", "1:9: keyword argument x repeated"},
+		{"fn(a, a = 1) { a }", "1:7: parameter a repeated"},
+		{"fn(a, [b, a]) { a }", "1:11: parameter a repeated"},
+		{"fn(a, ...a) { a }", "1:10: parameter a repeated"},
+		{"macro(x, x) { x }", "1:10: parameter x repeated"},
+	}
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.wantErr {
+			t.Fatalf("%q: parser errors want %q first, but got %q", tt.input, tt.wantErr, p.Errors())
+		}
+	}
+}
+
+func TestDestructuringPatterns(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{"let [first, second, ...tail] = arr;", "let [first, second, ...tail] = arr;"},
+		{"let {name, age} = person;", "let {name, age} = person;"},
+		{"let {name: n, tags: [t]} = person;", "let {name: n, tags: [t]} = person;"},
+		{"let [] = arr;", "let [] = arr;"},
+		{"fn([a, b], {c} = d) { a }", "fn([a, b], {c} = d) a"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if program.String() != tt.wantStr {
+				t.Fatalf("program.String() want %q, but got %q", tt.wantStr, program.String())
+			}
+		})
+	}
+
+	program := New(lexer.New("let [a, {b: [c], d}, ...e] = x;")).ParseProgram()
+	let := program.Statements[0].(*ast.LetStatement)
+	var names []string
+	for _, ident := range let.Names() {
+		names = append(names, ident.Value)
+	}
+	if fmt.Sprint(names) != "[a c d e]" {
+		t.Fatalf("let.Names() want [a c d e], but got %v", names)
+	}
+
+	errTests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"let [a, ...b, c] = x;", "1:13: expect next token to be ], but got ,"},
+		{"let [1] = x;", "1:6: expect pattern, but got INT"},
+		{`let {"a"} = x;`, "1:9: expect next token to be :, but got }"},
+		{"macro([a]) { a }", "1:7: macro parameter [a] cannot be a pattern"},
+	}
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.wantErr {
+			t.Fatalf("%q: parser errors want %q first, but got %q", tt.input, tt.wantErr, p.Errors())
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantStr string
+	}{
+		{
+			`match (x) { 1 => a, -2.5 => b, "s" => c, true => d, _ => e }`,
+			`match x { 1 => a, (-2.5) => b, "s" => c, true => d, _ => e }`,
+		},
+		{
+			`match (v) { [a, _, ...rest] if a > 1 => { rest } {"type": "x", data} => data }`,
+			`match v { [a, _, ...rest] if (a > 1) => rest, {"type": "x", data} => data }`,
+		},
+		{"match (xs) { [{k: [y]}] => y, }", "match xs { [{k: [y]}] => y }"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if program.String() != tt.wantStr {
+				t.Fatalf("program.String() want %q, but got %q", tt.wantStr, program.String())
+			}
+		})
+	}
+
+	program := New(lexer.New("match (x) { [a, 1] => a }")).ParseProgram()
+	match := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	pattern := match.Arms[0].Pattern.(*ast.ArrayPattern)
+	if _, ok := pattern.Elements[1].(*ast.LiteralPattern); !ok {
+		t.Fatalf("pattern.Elements[1] want *ast.LiteralPattern, but got %T", pattern.Elements[1])
+	}
+
+	errTests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"match (x) { }", "1:1: match without arms"},
+		{"match (x) { 1 => a 2 => b }", "1:20: expect next token to be ,, but got INT"},
+		{"match (x) { a + 1 => b }", "1:15: expect next token to be =>, but got +"},
+		{"match (x) { -a => b }", "1:13: expect pattern, but got -"},
+		{"let [a, 1] = x;", "1:9: expect pattern, but got INT"},
+	}
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.wantErr {
+			t.Fatalf("%q: parser errors want %q first, but got %q", tt.input, tt.wantErr, p.Errors())
+		}
+	}
+}
+
+func TestMacroLiteral(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantParams []string
+		wantBody   string
+	}{
+		{"macro(x, y) { x + y; }", []string{"x", "y"}, "(x + y)"},
+		{"macro() { x }", []string{}, "x"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+			if len(program.Statements) != 1 {
+				t.Fatalf("program.Statements len should be 1, but got %d", len(program.Statements))
+			}
+			exStmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+			if !ok {
+				t.Fatalf("should be *ast.ExpressionStatement, but got %T", program.Statements[0])
+			}
+			macro, ok := exStmt.Expression.(*ast.MacroLiteral)
+			if !ok {
+				t.Fatalf("should be *ast.MacroLiteral, but got %T", exStmt.Expression)
+			}
+			if len(macro.Parameters) != len(tt.wantParams) {
+				t.Fatalf("macro.Parameters len want %d, but got %d", len(tt.wantParams), len(macro.Parameters))
+			}
+			for i, param := range macro.Parameters {
+				if param.Value != tt.wantParams[i] {
+					t.Fatalf("macro.Parameters[%d] want %q, but got %q", i, tt.wantParams[i], param.Value)
+				}
+			}
+			if macro.Body.String() != tt.wantBody {
+				t.Fatalf("macro.Body.String() want %q, but got %q", tt.wantBody, macro.Body.String())
+			}
+		})
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	tests := []struct {
 		input   string
@@ -458,6 +999,21 @@ func TestComplexExpression(t *testing.T) {
 			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
 		},
 		{"true == false", "(true == false)"},
+		{"a <= b == c >= d", "((a <= b) == (c >= d))"},
+		{"a + b % c", "(a + (b % c))"},
+		{"a | b & c", "(a | (b & c))"},
+		{"a ^ b << 2", "(a ^ (b << 2))"},
+		{"a & 1 == 0", "((a & 1) == 0)"},
+		{"~a >> b", "((~a) >> b)"},
+		{"a + 1 <= b", "((a + 1) <= b)"},
+		{"a && b || c", "((a && b) || c)"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a == b && c < d", "((a == b) && (c < d))"},
+		{"x = a || b", "(x = (a || b))"},
+		{"x = 1 + 2", "(x = (1 + 2))"},
+		{"x = y = 3", "(x = (y = 3))"},
+		{"a[1] = b == c", "((a[1]) = (b == c))"},
+		{"h[a][b] = x * 2", "(((h[a])[b]) = (x * 2))"},
 		{
 			"true",
 			"true",
@@ -542,3 +1098,46 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	}
 	return true
 }
+
+func TestPositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, 2)`
+	l := lexer.NewFile("add.mk", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	if fn.Name != "add" {
+		t.Fatalf("function name want %q, but got %q", "add", fn.Name)
+	}
+	body := fn.Body
+	sum := body.Statements[0].(*ast.ExpressionStatement).Expression
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression
+	tests := []struct {
+		node ast.Node
+		want string
+	}{
+		{let, "add.mk:1:1"},
+		{let.Name, "add.mk:1:5"},
+		{let.Value, "add.mk:1:11"},
+		{body, "add.mk:1:20"},
+		{sum, "add.mk:2:5"},
+		{call, "add.mk:4:4"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.want {
+			t.Errorf("%s: position want %s, but got %s", tt.node, tt.want, got)
+		}
+	}
+
+	p = New(lexer.NewFile("add.mk", "let x = 1;\nlet = 2;"))
+	p.ParseProgram()
+	want := "add.mk:2:5: expect next token to be IDENT, but got ="
+	if len(p.Errors()) == 0 || p.Errors()[0] != want {
+		t.Fatalf("parser errors want %q first, but got %q", want, p.Errors())
+	}
+}
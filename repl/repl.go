@@ -2,6 +2,7 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 
@@ -18,6 +19,7 @@ const PROMPT = ">> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -33,7 +35,14 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			fmt.Fprintf(out, "Woops! Macro expansion failed:\n %s\n", err)
+			continue
+		}
+
+		evaluated := evaluator.Eval(expanded, env)
 		if evaluated != nil {
 			fmt.Fprintf(out, "%s\n", evaluated.Inspect())
 		}
@@ -49,6 +58,7 @@ func StartVM(in io.Reader, out io.Writer) {
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
+	macroEnv := object.NewEnvironment()
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -64,20 +74,33 @@ func StartVM(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			fmt.Fprintf(out, "Woops! Macro expansion failed:\n %s\n", err)
 			continue
 		}
 
+		comp := compiler.NewWithState(symbolTable, constants)
+		comp.SetModuleParser(evaluator.ParseModule)
+		err = comp.Compile(expanded)
 		code := comp.Bytecode()
+		// kept even when compiling fails, as the modules compiled on the
+		// way are cached and refer to their constants
 		constants = code.Constants
+		if err != nil {
+			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			continue
+		}
 
 		machine := vm.NewWithGlobalsStore(code, globals)
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			var runtimeErr *vm.RuntimeError
+			if errors.As(err, &runtimeErr) {
+				io.WriteString(out, runtimeErr.StackTrace())
+			}
 			continue
 		}
 
@@ -87,6 +110,44 @@ func StartVM(in io.Reader, out io.Writer) {
 	}
 }
 
+// RunFile compiles and runs the script in filename, writing any error to
+// out. Its imports are resolved relative to the script.
+func RunFile(filename string, out io.Writer) bool {
+	program, err := parser.ParseFile(filename)
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return false
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := evaluator.ExpandMacros(program, macroEnv)
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Macro expansion failed:\n %s\n", err)
+		return false
+	}
+
+	comp := compiler.New()
+	comp.SetModuleParser(evaluator.ParseModule)
+	err = comp.Compile(expanded)
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+		return false
+	}
+
+	machine := vm.New(comp.Bytecode())
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			io.WriteString(out, runtimeErr.StackTrace())
+		}
+		return false
+	}
+	return true
+}
+
 func printParseErrors(out io.Writer, errors []string) {
 	for _, msg := range errors {
 		fmt.Fprintf(out, "\t%s\n", msg)
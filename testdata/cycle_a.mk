@@ -0,0 +1,2 @@
+import "cycle_b.mk";
+export let a = 1;
@@ -0,0 +1,2 @@
+import "cycle_a.mk";
+export let b = 2;
@@ -0,0 +1 @@
+export let x = 1 / 0;
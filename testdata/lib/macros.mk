@@ -0,0 +1,3 @@
+let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+
+export let double = fn(n) { twice(n) };
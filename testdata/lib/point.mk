@@ -0,0 +1,2 @@
+export let [x, y] = [3, 4];
+export let {norm} = {"norm": fn() { x * x + y * y }};
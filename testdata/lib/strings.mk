@@ -0,0 +1,10 @@
+// helpers for working with strings
+let suffix = "!";
+let count = 0;
+
+export let shout = fn(s) { s + suffix };
+export let greeting = "hello";
+export let counter = fn() {
+  count = count + 1;
+  count
+};
@@ -0,0 +1,3 @@
+import "strings.mk";
+
+export let twice = fn(s) { strings["shout"](strings["shout"](s)) };
@@ -1,21 +1,60 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+}
+
+// Position is a place in a source file. Lines and columns count from 1,
+// columns in bytes; the zero Position is unknown.
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
 }
 
 const (
 	ILLEGAL TokenType = "ILLEGAL"
 	EOF     TokenType = "EOF"
 
+	// only produced by a lexer created with lexer.NewWithComments
+	COMMENT TokenType = "COMMENT"
+
 	// 标识符+字面量
 	IDENT  TokenType = "IDENT" // add, foobar, x, y, ...
 	INT    TokenType = "INT"   // 1343456
+	FLOAT  TokenType = "FLOAT" // 3.14, 1e-9
 	STRING TokenType = "STRING"
 
+	// "a ${x} b ${y} c" is lexed as INTERP_START("a ") x INTERP_MID(" b ")
+	// y INTERP_END(" c")
+	INTERP_START TokenType = "INTERP_START"
+	INTERP_MID   TokenType = "INTERP_MID"
+	INTERP_END   TokenType = "INTERP_END"
+
 	// 运算符
 	ASSIGN   TokenType = "="
 	PLUS     TokenType = "+"
@@ -23,15 +62,28 @@ const (
 	BANG     TokenType = "!"
 	ASTERISK TokenType = "*"
 	SLASH    TokenType = "/"
+	PERCENT  TokenType = "%"
+	BIT_AND  TokenType = "&"
+	BIT_OR   TokenType = "|"
+	CARET    TokenType = "^"
+	TILDE    TokenType = "~"
+	SHL      TokenType = "<<"
+	SHR      TokenType = ">>"
 	LT       TokenType = "<"
 	GT       TokenType = ">"
+	LT_EQ    TokenType = "<="
+	GT_EQ    TokenType = ">="
 	EQ       TokenType = "=="
 	NOT_EQ   TokenType = "!="
+	AND      TokenType = "&&"
+	OR       TokenType = "||"
 
 	// 分隔符
 	COMMA     TokenType = ","
 	SEMICOLON TokenType = ";"
 	COLON     TokenType = ":"
+	ELLIPSIS  TokenType = "..."
+	ARROW     TokenType = "=>"
 
 	LPAREN   TokenType = "("
 	RPAREN   TokenType = ")"
@@ -48,16 +100,42 @@ const (
 	IF       TokenType = "IF"
 	ELSE     TokenType = "ELSE"
 	RETURN   TokenType = "RETURN"
+	WHILE    TokenType = "WHILE"
+	BREAK    TokenType = "BREAK"
+	CONTINUE TokenType = "CONTINUE"
+	FOR      TokenType = "FOR"
+	IN       TokenType = "IN"
+	TRY      TokenType = "TRY"
+	CATCH    TokenType = "CATCH"
+	FINALLY  TokenType = "FINALLY"
+	THROW    TokenType = "THROW"
+	IMPORT   TokenType = "IMPORT"
+	EXPORT   TokenType = "EXPORT"
+	MACRO    TokenType = "MACRO"
+	MATCH    TokenType = "MATCH"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"for":      FOR,
+	"in":       IN,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
+	"import":   IMPORT,
+	"export":   EXPORT,
+	"macro":    MACRO,
+	"match":    MATCH,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lqqyt2423/go-monkey/object"
+	"github.com/lqqyt2423/go-monkey/token"
+)
+
+// RuntimeError is an error raised while running bytecode, along with the
+// call stack at the point it was raised. Err is an *object.Error unless
+// the bytecode itself is broken.
+type RuntimeError struct {
+	Err error
+	// Trace lists the active calls, innermost first; the last entry is the
+	// main program.
+	Trace []TraceEntry
+}
+
+// TraceEntry is one call on the stack: the function and the position it
+// had reached.
+type TraceEntry struct {
+	Function string
+	Pos      token.Position
+}
+
+func (e *RuntimeError) Error() string {
+	// a rethrown error keeps the position it was first raised at
+	pos := e.Trace[0].Pos
+	var objErr *object.Error
+	if errors.As(e.Err, &objErr) {
+		pos = objErr.Pos
+	}
+	return fmt.Sprintf("%s: %s", pos, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// StackTrace formats the trace, one call per line.
+func (e *RuntimeError) StackTrace() string {
+	var out strings.Builder
+	for _, entry := range e.Trace {
+		fmt.Fprintf(&out, "    at %s (%s)\n", entry.Function, entry.Pos)
+	}
+	return out.String()
+}
+
+// newRuntimeError wraps err with the current call stack.
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	trace := make([]TraceEntry, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		name := frame.Name()
+		if i == 0 {
+			name = "<main>"
+		}
+		trace = append(trace, TraceEntry{Function: name, Pos: frame.Pos()})
+	}
+	var objErr *object.Error
+	if errors.As(err, &objErr) && !objErr.Pos.IsValid() {
+		objErr.Pos = trace[0].Pos
+	}
+	return &RuntimeError{Err: err, Trace: trace}
+}
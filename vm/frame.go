@@ -3,18 +3,35 @@ package vm
 import (
 	"github.com/lqqyt2423/go-monkey/code"
 	"github.com/lqqyt2423/go-monkey/object"
+	"github.com/lqqyt2423/go-monkey/token"
 )
 
 type Frame struct {
-	fn          *object.CompiledFunction
+	cl          *object.Closure
 	ip          int
 	basePointer int
+	// loops holds the stack height at the start of each loop the frame
+	// is running, innermost last, for break and continue to go back to.
+	loops []int
 }
 
-func NewFrame(fn *object.CompiledFunction, basePointer int) *Frame {
-	return &Frame{fn: fn, ip: -1, basePointer: basePointer}
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
 }
 
 func (f *Frame) Instructions() code.Instructions {
-	return f.fn.Instructions
+	return f.cl.Fn.Instructions
+}
+
+// Pos is the source position of the instruction the frame is executing.
+func (f *Frame) Pos() token.Position {
+	return f.cl.Fn.Positions.Lookup(f.ip)
+}
+
+// Name is the name of the function the frame is running, for stack traces.
+func (f *Frame) Name() string {
+	if f.cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return f.cl.Fn.Name
 }
@@ -1,7 +1,10 @@
 package vm
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/lqqyt2423/go-monkey/code"
 	"github.com/lqqyt2423/go-monkey/compiler"
@@ -12,8 +15,19 @@ var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	// missing fills the slot of a parameter left to its default until the
+	// function's prologue computes it.
+	missing = &missingArgument{}
 )
 
+// missingArgument is the type of missing. It is not zero-size, so that
+// missing cannot share its address with NULL.
+type missingArgument struct {
+	object.Null
+	_ byte
+}
+
 const (
 	MaxFrames   = 1024
 	StackSize   = 2048
@@ -23,24 +37,52 @@ const (
 type VM struct {
 	constants []object.Object
 	globals   []object.Object
+	// globalNames names the global slots, for reading one not yet set.
+	globalNames []string
 
 	stack []object.Object
 	sp    int
 
 	frames      []*Frame
 	framesIndex int
+
+	// openFreeVars are the captured variables that still live on the stack,
+	// ordered by slot.
+	openFreeVars []*openFreeVar
+
+	// handlers are the try blocks being run, innermost last.
+	handlers []handler
+}
+
+// handler records where to resume when an error is raised inside a try
+// block: the frame, stack height and loops at the try and its catch code.
+type handler struct {
+	framesIndex int
+	sp          int
+	loops       int
+	catchIP     int
+}
+
+type openFreeVar struct {
+	slot int
+	fv   *object.FreeVar
 }
 
 func New(bytecode *compiler.ByteCode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
-	mainFrame := NewFrame(mainFn, 0)
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 
 	return &VM{
-		constants: bytecode.Constants,
-		globals:   make([]object.Object, GlobalsSize),
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
 
 		stack: make([]object.Object, StackSize),
 		sp:    0,
@@ -67,7 +109,51 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// Run executes the bytecode. Errors are returned as a *RuntimeError.
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if !vm.catch(err) {
+			return vm.newRuntimeError(err)
+		}
+	}
+}
+
+// catch unwinds the frames and the stack to the innermost handler and
+// resumes at its catch code with the error pushed, reporting false when
+// there is no handler or no room on the stack for the error. Errors that
+// are not *object.Error come from broken bytecode and are never caught.
+func (vm *VM) catch(err error) bool {
+	var objErr *object.Error
+	if len(vm.handlers) == 0 || !errors.As(err, &objErr) {
+		return false
+	}
+	if !objErr.Pos.IsValid() {
+		objErr.Pos = vm.currentFrame().Pos()
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	if h.sp >= StackSize {
+		// no room to hand the error to the catch code
+		return false
+	}
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	for vm.framesIndex > h.framesIndex {
+		frame := vm.popFrame()
+		vm.closeFreeVars(frame.basePointer)
+	}
+	vm.sp = h.sp
+	frame := vm.currentFrame()
+	frame.loops = frame.loops[:h.loops]
+	frame.ip = h.catchIP - 1
+	vm.stack[vm.sp] = objErr
+	vm.sp++
+	return true
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -87,29 +173,52 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			vm.execBinaryOperation(op)
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			err := vm.execBinaryOperation(op)
+			if err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
-		case code.OpTrue:
-			vm.push(TRUE)
-		case code.OpFalse:
-			vm.push(FALSE)
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			vm.execCompareOperation(op)
+		case code.OpTrue, code.OpFalse:
+			err := vm.push(nativeBoolToBooleanObject(op == code.OpTrue))
+			if err != nil {
+				return err
+			}
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+			code.OpLessThan, code.OpLessThanOrEqual:
+			err := vm.execCompareOperation(op)
+			if err != nil {
+				return err
+			}
 		case code.OpMinus:
+			var result object.Object
+			switch val := vm.pop().(type) {
+			case *object.Integer, *object.BigInteger:
+				result = object.NegateInteger(val)
+			case *object.Float:
+				result = &object.Float{Value: -val.Value}
+			default:
+				return object.NewError(object.TypeError, "unknown operator: -%s", val.Type())
+			}
+			err := vm.push(result)
+			if err != nil {
+				return err
+			}
+		case code.OpBitNot:
 			val := vm.pop()
-			if val.Type() != object.INTEGER_OBJ {
-				return fmt.Errorf("unsupported type for minus operation: %s", val.Type())
+			if !object.IsInteger(val) {
+				return object.NewError(object.TypeError, "unknown operator: ~%s", val.Type())
+			}
+			err := vm.push(object.BitNotInteger(val))
+			if err != nil {
+				return err
 			}
-			v := val.(*object.Integer).Value
-			vm.push(&object.Integer{Value: -v})
 		case code.OpBang:
-			val := vm.pop()
-			if isTruthy(val) {
-				vm.push(FALSE)
-			} else {
-				vm.push(TRUE)
+			err := vm.push(nativeBoolToBooleanObject(!isTruthy(vm.pop())))
+			if err != nil {
+				return err
 			}
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
@@ -121,8 +230,20 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			jumpIf := op == code.OpJumpTruthyOrPop
+			if isTruthy(vm.stack[vm.sp-1]) == jumpIf {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
 		case code.OpNull:
-			vm.push(NULL)
+			err := vm.push(NULL)
+			if err != nil {
+				return err
+			}
 		case code.OpSetGlobal:
 			index := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -130,7 +251,15 @@ func (vm *VM) Run() error {
 		case code.OpGetGlobal:
 			index := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
-			vm.push(vm.globals[index])
+			global := vm.globals[index]
+			if global == nil {
+				// a function declared further down, whose let has not run yet
+				return object.NewError(object.NameError, "identifier not found: %s", vm.globalNames[index])
+			}
+			err := vm.push(global)
+			if err != nil {
+				return err
+			}
 		case code.OpSetLocal:
 			localIndex := int(ins[ip+1])
 			vm.currentFrame().ip += 1
@@ -140,12 +269,23 @@ func (vm *VM) Run() error {
 			localIndex := int(ins[ip+1])
 			vm.currentFrame().ip += 1
 			frame := vm.currentFrame()
-			vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if local == nil {
+				// as for globals, a let that has not run yet
+				return object.NewError(object.NameError, "identifier not found: %s", frame.cl.Fn.Locals[localIndex])
+			}
+			err := vm.push(local)
+			if err != nil {
+				return err
+			}
 		case code.OpGetBuiltin:
 			builtinIndex := int(ins[ip+1])
 			vm.currentFrame().ip += 1
 			definition := object.Builtins[builtinIndex]
-			vm.push(definition.Builtin)
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
 		case code.OpArray:
 			arrLen := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -153,83 +293,337 @@ func (vm *VM) Run() error {
 			for i := arrLen - 1; i >= 0; i-- {
 				arr.Elements[i] = vm.pop()
 			}
-			vm.push(arr)
-		case code.OpIndex:
-			idx := vm.pop()
-			if idx.Type() != object.INTEGER_OBJ {
-				return fmt.Errorf("invalid index type %s", idx.Type())
+			err := vm.push(arr)
+			if err != nil {
+				return err
 			}
-			arr := vm.pop()
-			if arr.Type() != object.ARRAY_OBJ {
-				return fmt.Errorf("type %s can not index", arr.Type())
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
 			}
-			idxVal := idx.(*object.Integer).Value
-			arrElements := arr.(*object.Array).Elements
-			if idxVal < 0 || idxVal >= int64(len(arrElements)) {
-				vm.push(NULL)
-			} else {
-				vm.push(arrElements[idxVal])
+			vm.sp = vm.sp - numElements
+			err = vm.push(hash)
+			if err != nil {
+				return err
+			}
+		case code.OpConcat:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			var out strings.Builder
+			for _, part := range vm.stack[vm.sp-numParts : vm.sp] {
+				out.WriteString(object.Display(part))
+			}
+			vm.sp = vm.sp - numParts
+			err := vm.push(&object.String{Value: out.String()})
+			if err != nil {
+				return err
+			}
+		case code.OpIterator:
+			iterable, ok := vm.pop().(object.Iterable)
+			if !ok {
+				return object.NewError(object.TypeError, "not iterable: %s", vm.stack[vm.sp].Type())
+			}
+			err := vm.push(iterable.Iterator())
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			key, value, ok := vm.stack[vm.sp-1].(*object.Iterator).Next()
+			if !ok {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			err := vm.push(key)
+			if err != nil {
+				return err
+			}
+			err = vm.push(value)
+			if err != nil {
+				return err
+			}
+		case code.OpIndex:
+			idx := vm.pop()
+			left := vm.pop()
+			err := vm.execIndexExpression(left, idx)
+			if err != nil {
+				return err
 			}
 		case code.OpCall:
 			numArgs := int(ins[ip+1])
 			vm.currentFrame().ip += 1
-
-			callee := vm.stack[vm.sp-numArgs-1]
-			switch callee := callee.(type) {
-			case *object.CompiledFunction:
-				fn := callee
-				if numArgs != fn.NumParameters {
-					return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
-				}
-				frame := NewFrame(fn, vm.sp-numArgs)
-				vm.pushFrame(frame)
-				vm.sp = frame.basePointer + fn.NumLocals
-			case *object.Builtin:
-				builtin := callee
-				args := vm.stack[vm.sp-numArgs : vm.sp]
-				result := builtin.Fn(args...)
-				vm.sp = vm.sp - numArgs - 1
-				if result != nil {
-					vm.push(result)
-				} else {
-					vm.push(NULL)
-				}
-			default:
-				return fmt.Errorf("calling non-function")
+			err := vm.callFunction(numArgs, nil)
+			if err != nil {
+				return err
+			}
+		case code.OpCallKw:
+			numArgs := int(ins[ip+1])
+			namesIndex := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+			var keywords []string
+			for _, name := range vm.constants[namesIndex].(*object.Array).Elements {
+				keywords = append(keywords, name.(*object.String).Value)
+			}
+			err := vm.callFunction(numArgs, keywords)
+			if err != nil {
+				return err
+			}
+		case code.OpUnpackArray, code.OpMatchArray:
+			n := int(ins[ip+1])
+			hasRest := ins[ip+2] == 1
+			vm.currentFrame().ip += 2
+			parts, splitErr := object.DestructureArray(vm.pop(), n, hasRest)
+			if err := vm.pushParts(op == code.OpMatchArray, parts, splitErr); err != nil {
+				return err
+			}
+		case code.OpUnpackHash, code.OpMatchHash:
+			keysIndex := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			var keys []string
+			for _, key := range vm.constants[keysIndex].(*object.Array).Elements {
+				keys = append(keys, key.(*object.String).Value)
+			}
+			parts, splitErr := object.DestructureHash(vm.pop(), keys)
+			if err := vm.pushParts(op == code.OpMatchHash, parts, splitErr); err != nil {
+				return err
+			}
+		case code.OpNoMatch:
+			return object.NewError(object.MatchError, "no match for %s", vm.pop().Inspect())
+		case code.OpJumpIfPassed:
+			localIndex := int(ins[ip+1])
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+			frame := vm.currentFrame()
+			if vm.stack[frame.basePointer+localIndex] != missing {
+				frame.ip = pos - 1
 			}
 
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			vm.push(returnValue)
-		case code.OpReturn:
+		case code.OpTry:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, loops: len(vm.currentFrame().loops), catchIP: pos})
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case code.OpLoop:
+			frame := vm.currentFrame()
+			frame.loops = append(frame.loops, vm.sp)
+		case code.OpEndLoop:
+			frame := vm.currentFrame()
+			frame.loops = frame.loops[:len(frame.loops)-1]
+		case code.OpUnwindLoop:
+			// drop what the expressions around a break or continue pushed
+			loops := vm.currentFrame().loops
+			vm.sp = loops[len(loops)-1]
+		case code.OpThrow:
+			val := vm.pop()
+			if err, ok := val.(*object.Error); ok {
+				return err
+			}
+			return object.NewThrow(val)
+		case code.OpCatch:
+			err := vm.stack[vm.sp-1].(*object.Error)
+			vm.stack[vm.sp-1] = err.CatchValue()
+
+		case code.OpReturnValue, code.OpReturn:
+			var returnValue object.Object = NULL
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
+			}
 			frame := vm.popFrame()
+			vm.closeFreeVars(frame.basePointer)
 			vm.sp = frame.basePointer - 1
-			vm.push(NULL)
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+		case code.OpImport:
+			constIndex := int(code.ReadUint16(ins[ip+1:]))
+			slot := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+			if mod := vm.globals[slot]; mod != nil {
+				err := vm.push(mod)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := vm.runModule(constIndex)
+			if err != nil {
+				return err
+			}
+		case code.OpModule:
+			slot := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			exports := vm.pop().(*object.Hash)
+			mod := &object.Module{Name: vm.currentFrame().cl.Fn.Name, Exports: exports}
+			vm.globals[slot] = mod
+			err := vm.push(mod)
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			err := vm.pushClosure(constIndex)
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := int(ins[ip+1])
+			vm.currentFrame().ip += 1
+			cl := vm.currentFrame().cl
+			free := cl.Free[freeIndex].Get()
+			if free == nil {
+				return object.NewError(object.NameError, "identifier not found: %s", cl.Fn.Captures[freeIndex].Name)
+			}
+			err := vm.push(free)
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := int(ins[ip+1])
+			vm.currentFrame().ip += 1
+			vm.currentFrame().cl.Free[freeIndex].Set(vm.pop())
+		case code.OpSetIndex:
+			val := vm.pop()
+			idx := vm.pop()
+			left := vm.pop()
+			err := vm.execSetIndex(left, idx, val)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, object.NewError(object.TypeError, "unusable as hash key: %s", key.Type())
+		}
+		hash.Pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return hash, nil
+}
+
+func (vm *VM) execIndexExpression(left, index object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, err := left.Index(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(left.Elements[i])
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return object.NewError(object.TypeError, "unusable as hash key: %s", index.Type())
+		}
+		pair, ok := left.Pairs[key.HashKey()]
+		if !ok {
+			return vm.push(NULL)
+		}
+		return vm.push(pair.Value)
+	case *object.Module:
+		value, err := left.Export(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(value)
+	default:
+		return object.NewError(object.TypeError, "index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) execSetIndex(left, index, val object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, err := left.Index(index)
+		if err != nil {
+			return err
+		}
+		left.Elements[i] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return object.NewError(object.TypeError, "unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+	default:
+		return object.NewError(object.TypeError, "index operator not supported: %s", left.Type())
+	}
+	return vm.push(val)
+}
+
 func (vm *VM) execBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 	rightType := right.Type()
 	leftType := left.Type()
-	if rightType == object.INTEGER_OBJ && leftType == object.INTEGER_OBJ {
+	if object.IsInteger(left) && object.IsInteger(right) {
 		return vm.execBinaryIntegerOperation(op, left, right)
 	}
 	if rightType == object.STRING_OBJ && leftType == object.STRING_OBJ {
 		return vm.execBinaryStringOperation(op, left, right)
 	}
-	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+	if rightType == object.FLOAT_OBJ || leftType == object.FLOAT_OBJ {
+		_, leftOk := object.FloatValue(left)
+		_, rightOk := object.FloatValue(right)
+		if leftOk && rightOk {
+			return vm.execBinaryFloatOperation(op, left, right)
+		}
+	}
+	return object.NewInfixError(operators[op], left, right)
+}
+
+// operators gives the source operator of each binary opcode, for integer
+// arithmetic and for error messages.
+var operators = map[code.Opcode]string{
+	code.OpAdd:        "+",
+	code.OpSub:        "-",
+	code.OpMul:        "*",
+	code.OpDiv:        "/",
+	code.OpMod:        "%",
+	code.OpBitAnd:     "&",
+	code.OpBitOr:      "|",
+	code.OpBitXor:     "^",
+	code.OpShiftLeft:  "<<",
+	code.OpShiftRight: ">>",
+
+	code.OpEqual:              "==",
+	code.OpNotEqual:           "!=",
+	code.OpGreaterThan:        ">",
+	code.OpGreaterThanOrEqual: ">=",
+	code.OpLessThan:           "<",
+	code.OpLessThanOrEqual:    "<=",
 }
 
 func (vm *VM) execBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-	var result int64
+	operator, ok := operators[op]
+	if !ok {
+		return fmt.Errorf("invalid op %v", op)
+	}
+	result, err := object.IntegerOperation(operator, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) execBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftVal, _ := object.FloatValue(left)
+	rightVal, _ := object.FloatValue(right)
+	var result float64
 	switch op {
 	case code.OpAdd:
 		result = leftVal + rightVal
@@ -238,22 +632,23 @@ func (vm *VM) execBinaryIntegerOperation(op code.Opcode, left, right object.Obje
 	case code.OpMul:
 		result = leftVal * rightVal
 	case code.OpDiv:
+		if rightVal == 0 {
+			return object.NewError(object.ZeroDivisionError, "division by zero")
+		}
 		result = leftVal / rightVal
 	default:
-		return fmt.Errorf("invalid op %v", op)
+		return object.NewInfixError(operators[op], left, right)
 	}
-	vm.push(&object.Integer{Value: result})
-	return nil
+	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) execBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %v", op)
+		return object.NewInfixError(operators[op], left, right)
 	}
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	vm.push(&object.String{Value: leftVal + rightVal})
-	return nil
+	return vm.push(&object.String{Value: leftVal + rightVal})
 }
 
 func (vm *VM) execCompareOperation(op code.Opcode) error {
@@ -262,62 +657,66 @@ func (vm *VM) execCompareOperation(op code.Opcode) error {
 	rightType := right.Type()
 	leftType := left.Type()
 
-	if rightType == object.INTEGER_OBJ && leftType == object.INTEGER_OBJ {
-		leftVal := left.(*object.Integer).Value
-		rightVal := right.(*object.Integer).Value
-		switch op {
-		case code.OpGreaterThan:
-			if leftVal > rightVal {
-				vm.push(nativeBoolToBooleanObject(true))
-			} else {
-				vm.push(nativeBoolToBooleanObject(false))
-			}
-		case code.OpEqual:
-			if leftVal == rightVal {
-				vm.push(nativeBoolToBooleanObject(true))
-			} else {
-				vm.push(nativeBoolToBooleanObject(false))
-			}
-		case code.OpNotEqual:
-			if leftVal != rightVal {
-				vm.push(nativeBoolToBooleanObject(true))
-			} else {
-				vm.push(nativeBoolToBooleanObject(false))
-			}
-		default:
-			return fmt.Errorf("invalid op %v", op)
+	if object.IsInteger(left) && object.IsInteger(right) {
+		result, err := compareOrdered(op, object.CompareIntegers(left, right), 0)
+		if err != nil {
+			return err
 		}
-		return nil
+		return vm.push(nativeBoolToBooleanObject(result))
 	}
-
-	if rightType == object.BOOLEAN_OBJ && leftType == object.BOOLEAN_OBJ {
-		leftVal := left.(*object.Boolean).Value
-		rightVal := right.(*object.Boolean).Value
-		switch op {
-		case code.OpEqual:
-			if leftVal == rightVal {
-				vm.push(nativeBoolToBooleanObject(true))
-			} else {
-				vm.push(nativeBoolToBooleanObject(false))
-			}
-		case code.OpNotEqual:
-			if leftVal != rightVal {
-				vm.push(nativeBoolToBooleanObject(true))
-			} else {
-				vm.push(nativeBoolToBooleanObject(false))
+	if rightType == object.FLOAT_OBJ || leftType == object.FLOAT_OBJ {
+		leftVal, leftOk := object.FloatValue(left)
+		rightVal, rightOk := object.FloatValue(right)
+		if leftOk && rightOk {
+			result, err := compareOrdered(op, leftVal, rightVal)
+			if err != nil {
+				return err
 			}
-		default:
-			return fmt.Errorf("invalid op %v", op)
+			return vm.push(nativeBoolToBooleanObject(result))
 		}
-		return nil
+	}
+	if rightType == object.STRING_OBJ && leftType == object.STRING_OBJ {
+		leftVal := left.(*object.String).Value
+		rightVal := right.(*object.String).Value
+		result, err := compareOrdered(op, leftVal, rightVal)
+		if err != nil {
+			return err
+		}
+		return vm.push(nativeBoolToBooleanObject(result))
 	}
 
-	return fmt.Errorf("unsupported types for compare operation: %s %v %s", leftType, op, rightType)
+	// booleans and null are singletons, anything else is only equal to itself
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left == right))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(left != right))
+	}
+	return object.NewInfixError(operators[op], left, right)
+}
+
+func compareOrdered[T cmp.Ordered](op code.Opcode, left, right T) (bool, error) {
+	switch op {
+	case code.OpGreaterThan:
+		return left > right, nil
+	case code.OpGreaterThanOrEqual:
+		return left >= right, nil
+	case code.OpLessThan:
+		return left < right, nil
+	case code.OpLessThanOrEqual:
+		return left <= right, nil
+	case code.OpEqual:
+		return left == right, nil
+	case code.OpNotEqual:
+		return left != right, nil
+	default:
+		return false, fmt.Errorf("invalid op %v", op)
+	}
 }
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return object.NewError(object.StackOverflowError, "stack overflow")
 	}
 	vm.stack[vm.sp] = o
 	vm.sp++
@@ -344,6 +743,163 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// pushParts pushes the parts of a destructured value last first, so that
+// the pattern binds them in order as it pops them. For a match it pushes
+// whether the value matched on top, and a failed destructuring is not an
+// error.
+func (vm *VM) pushParts(match bool, parts []object.Object, err *object.Error) error {
+	if err != nil {
+		if match {
+			return vm.push(FALSE)
+		}
+		return err
+	}
+	for i := len(parts) - 1; i >= 0; i-- {
+		err := vm.push(parts[i])
+		if err != nil {
+			return err
+		}
+	}
+	if match {
+		return vm.push(TRUE)
+	}
+	return nil
+}
+
+// callFunction calls the callee below the numArgs arguments on the stack,
+// the last len(keywords) of which are the named keyword arguments.
+func (vm *VM) callFunction(numArgs int, keywords []string) error {
+	callee := vm.stack[vm.sp-numArgs-1]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs, keywords)
+	case *object.Builtin:
+		if len(keywords) > 0 {
+			return object.NewError(object.ArityError, "unknown keyword argument: %s", keywords[0])
+		}
+		args := vm.stack[vm.sp-numArgs : vm.sp]
+		result := callee.Fn(args...)
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+		vm.sp = vm.sp - numArgs - 1
+		if result != nil {
+			return vm.push(result)
+		}
+		return vm.push(NULL)
+	default:
+		return object.NewError(object.TypeError, "not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int, keywords []string) error {
+	fn := cl.Fn
+	if len(keywords) > 0 || fn.Variadic || numArgs != fn.NumParameters {
+		bound, passed, err := fn.Signature().Bind(vm.stack[vm.sp-numArgs:vm.sp], keywords)
+		if err != nil {
+			return err
+		}
+		vm.sp -= numArgs
+		for i, arg := range bound {
+			if i < len(passed) && !passed[i] {
+				arg = missing
+			}
+			err := vm.push(arg)
+			if err != nil {
+				return err
+			}
+		}
+		numArgs = len(bound)
+	}
+	if vm.framesIndex >= MaxFrames || vm.sp-numArgs+fn.NumLocals > StackSize {
+		return object.NewError(object.StackOverflowError, "stack overflow")
+	}
+	frame := NewFrame(cl, vm.sp-numArgs)
+	vm.pushFrame(frame)
+	vm.sp = frame.basePointer + fn.NumLocals
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		// unset until the let for the local runs
+		vm.stack[i] = nil
+	}
+	return nil
+}
+
+// runModule calls the function of a module that has not run yet. It
+// returns the module, which is then kept in its global slot.
+func (vm *VM) runModule(constIndex int) error {
+	fn, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a module: %+v", vm.constants[constIndex])
+	}
+	if vm.framesIndex >= MaxFrames || vm.sp+1+fn.NumLocals > StackSize {
+		return object.NewError(object.StackOverflowError, "stack overflow")
+	}
+	cl := &object.Closure{Fn: fn}
+	err := vm.push(cl)
+	if err != nil {
+		return err
+	}
+	frame := NewFrame(cl, vm.sp)
+	vm.pushFrame(frame)
+	vm.sp = frame.basePointer + fn.NumLocals
+	return nil
+}
+
+func (vm *VM) pushClosure(constIndex int) error {
+	constant := vm.constants[constIndex]
+	fn, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	frame := vm.currentFrame()
+	free := make([]*object.FreeVar, len(fn.Captures))
+	for i, capture := range fn.Captures {
+		switch capture.Scope {
+		case object.CaptureLocal:
+			free[i] = vm.captureLocal(frame.basePointer + capture.Index)
+		case object.CaptureFree:
+			free[i] = frame.cl.Free[capture.Index]
+		}
+	}
+	return vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+// captureLocal returns the FreeVar for a stack slot of the current frame,
+// sharing it between all closures that capture the same slot.
+func (vm *VM) captureLocal(slot int) *object.FreeVar {
+	for i := len(vm.openFreeVars) - 1; i >= 0; i-- {
+		open := vm.openFreeVars[i]
+		if open.slot == slot {
+			return open.fv
+		}
+		if open.slot < slot {
+			break
+		}
+	}
+
+	fv := object.NewFreeVar(&vm.stack[slot])
+	i := len(vm.openFreeVars)
+	for i > 0 && vm.openFreeVars[i-1].slot > slot {
+		i--
+	}
+	vm.openFreeVars = append(vm.openFreeVars, nil)
+	copy(vm.openFreeVars[i+1:], vm.openFreeVars[i:])
+	vm.openFreeVars[i] = &openFreeVar{slot: slot, fv: fv}
+	return fv
+}
+
+// closeFreeVars detaches every captured variable at or above basePointer
+// from the stack before the frame owning them is discarded.
+func (vm *VM) closeFreeVars(basePointer int) {
+	n := len(vm.openFreeVars)
+	for n > 0 && vm.openFreeVars[n-1].slot >= basePointer {
+		vm.openFreeVars[n-1].fv.Close()
+		n--
+	}
+	vm.openFreeVars = vm.openFreeVars[:n]
+}
+
 func nativeBoolToBooleanObject(b bool) *object.Boolean {
 	if b {
 		return TRUE
@@ -1,11 +1,15 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/lqqyt2423/go-monkey/ast"
 	"github.com/lqqyt2423/go-monkey/compiler"
+	"github.com/lqqyt2423/go-monkey/evaluator"
 	"github.com/lqqyt2423/go-monkey/lexer"
 	"github.com/lqqyt2423/go-monkey/object"
 	"github.com/lqqyt2423/go-monkey/parser"
@@ -32,6 +36,29 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func bigInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return v
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -86,6 +113,34 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+func runVmErrorTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+		var errObj *object.Error
+		if !errors.As(err, &errObj) {
+			t.Fatalf("VM error should wrap *object.Error, but got %T", err)
+		}
+	}
+}
+
 func testExpectedObject(
 	t *testing.T,
 	expected interface{},
@@ -99,6 +154,20 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case *big.Int:
+		result, ok := actual.(*object.BigInteger)
+		if !ok {
+			t.Errorf("object is not BigInteger. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value.Cmp(expected) != 0 {
+			t.Errorf("object has wrong value. got=%s, want=%s", result.Value, expected)
+		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(expected, actual)
 		if err != nil {
@@ -132,6 +201,30 @@ func testExpectedObject(
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
+			return
+		}
+
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
+				len(expected), len(hash.Pairs))
+			return
+		}
+
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			err := testIntegerObject(expectedValue, pair.Value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
 	case *object.Error:
 		errObj, ok := actual.(*object.Error)
 		if !ok {
@@ -155,11 +248,39 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"6 / 2", 3},
 		{"6 / (2 + 1)", 2},
 		{"-1", -1},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 4", 16},
+		{"-16 >> 2", -4},
+		{"~5", -6},
+		{"~-1", 0},
+		{"1 << 62 >> 60", 4},
+		{"1 + 6 & 3", 3},
+		{"1 | 2 * 4", 9},
+		{"5 ^ 1 + 1", 5},
+		{"(17 * 31 + 5) % 8", 4},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestIntegerArithmeticErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 / 0", "1:3: ZeroDivisionError: division by zero"},
+		{"let x = 0; 10 % x", "1:15: ZeroDivisionError: division by zero"},
+		{"1 << -1", "1:3: ValueError: negative shift count: -1"},
+		{"~true", "1:1: TypeError: unknown operator: ~BOOLEAN"},
+		{`"a" - "b"`, "1:5: TypeError: unknown operator: STRING - STRING"},
+		{`1 + "a"`, "1:3: TypeError: type mismatch: INTEGER + STRING"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -184,6 +305,27 @@ func TestBooleanExpressions(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1<=2", true},
+		{"2<=2", true},
+		{"3<=2", false},
+		{"1>=2", false},
+		{"2>=2", true},
+		{"3>=2", true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"a" > "b"`, false},
+		{`"abc" > "abd"`, false},
+		{`"ab" < "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"b" >= "a"`, true},
+		{`"" < "a"`, true},
+		{`"hello" == "hello"`, true},
+		{`"hello" == "world"`, false},
+		{`"hello" != "hello"`, false},
+		{`"hello" != "world"`, true},
+		{`1 == "1"`, false},
+		{`1 != "1"`, true},
+		{"true == 1", false},
 		{"if (true) { true }", true},
 		{"!true", false},
 		{"!1", false},
@@ -226,6 +368,16 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"line\n" + "\ttab"`, "line\n\ttab"},
+		{`"say \"hi\""`, `say "hi"`},
+		{"`raw\n\\n`", "raw\n\\n"},
+		{`len("a\nb")`, 3},
+		{`let name = "lq"; "hello ${name}!"`, "hello lq!"},
+		{`let items = [1, 2]; "you have ${len(items)} items: ${items}"`, "you have 2 items: [1, 2]"},
+		{`"${1 + 1.5} ${true} ${if (false) { 1 }} ${"s"}"`, "2.5 true null s"},
+		{`let x = 3; "${ "<${x * 2}>" }"`, "<6>"},
+		{`"no ${"nested ${"deep"}"} end"`, "no nested deep end"},
+		{`let f = fn(n) { "n=${n}" }; f(1) + ", " + f(2)`, "n=1, n=2"},
 	}
 
 	runVmTests(t, tests)
@@ -241,19 +393,90 @@ func TestArrayLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			`{"one": 1, "two": 2}`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "one"}).HashKey(): 1,
+				(&object.String{Value: "two"}).HashKey(): 2,
+			},
+		},
+		{
+			`{"o" + "ne": 2 * 2, "two": 3 + 3}`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "one"}).HashKey(): 4,
+				(&object.String{Value: "two"}).HashKey(): 6,
+			},
+		},
+		{
+			`{1: 2, 2: 3}`,
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 2,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+			},
+		},
+		{
+			`{1 + 1: 2 * 2, 3 + 3: 4 * 4}`,
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 2}).HashKey(): 4,
+				(&object.Integer{Value: 6}).HashKey(): 16,
+			},
+		},
+		{
+			`{true: 1, false: 0}`,
+			map[object.HashKey]int64{
+				TRUE.HashKey():  1,
+				FALSE.HashKey(): 0,
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestIndexExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"[1, 2, 3][1]", 2},
 		{"[1, 2, 3][0 + 2]", 3},
 		{"[[1, 1, 1]][0][0]", 1},
-		{"[][0]", NULL},
-		{"[1, 2, 3][99]", NULL},
-		{"[1][-1]", NULL},
+		{`{"a": 1}["a"]`, 1},
+		{`{"a": 1, "b": 2}["b"]`, 2},
+		{`{"a": 1}["b"]`, NULL},
+		{`{}["a"]`, NULL},
+		{`let h = {"a": {"b": 5}}; h["a"]["b"]`, 5},
+		{`{1: 1, 2: 2}[1]`, 1},
+		{`{1: 1, 2: 2}[2]`, 2},
+		{`{1: 1}[0]`, NULL},
+		{`{true: "yes", false: "no"}[1 > 0]`, "yes"},
+		{`{1: "one", "1": "string one"}["1"]`, "string one"},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestIndexErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"[][0]", "1:3: IndexError: index out of range: 0"},
+		{"[1, 2, 3][99]", "1:10: IndexError: index out of range: 99"},
+		{"[1][-1]", "1:4: IndexError: index out of range: -1"},
+		{"[1][1 << 64]", "1:4: IndexError: index out of range: 18446744073709551616"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestUnusableHashKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{`{[1]: 1}`, "1:1: TypeError: unusable as hash key: ARRAY"},
+		{`{{}: 1}`, "1:1: TypeError: unusable as hash key: HASH"},
+		{`{"a": 1}[fn() { 1 }]`, "1:9: TypeError: unusable as hash key: FUNCTION"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -476,63 +699,1118 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestVariadicFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let count = fn(...xs) { len(xs) }; count() + count(1, 2, 3);", 3},
+		{"let f = fn(a, ...rest) { a + len(rest) }; f(10) + f(10, 20, 30);", 22},
+		{"let sum = fn(...xs) { let s = 0; for (x in xs) { s = s + x }; s }; sum(1, 2, 3, 4);", 10},
+		{"let last = fn(a, b, ...rest) { rest[len(rest) - 1] }; last(1, 2, 3, 4);", 4},
+		{"let outer = fn(x) { fn(...ys) { x + len(ys) } }; outer(5)(1, 1);", 7},
+		{"let f = fn(...xs) { xs[0] = 9; xs[0] }; f(1);", 9},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDefaultsAndKeywordArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open("a")`, "a:r"},
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open("a", "w")`, "a:w"},
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open("a", mode: "w")`, "a:w"},
+		{`let open = fn(path, mode = "r") { path + ":" + mode }; open(mode: "w", path: "b")`, "b:w"},
+		{"let f = fn(a, b = a * 2) { a + b }; f(3)", 9},
+		{"let f = fn(a = 1, b = 2, c = 3) { a * 100 + b * 10 + c }; f(b: 5)", 153},
+		{"let n = 0; let f = fn(x = n) { x }; n = 7; f()", 7},
+		{"let f = fn(a, b = 10, ...rest) { a + b + len(rest) }; f(1) + f(1, 2, 3, 4)", 16},
+		{"let f = fn(x = if (true) { 5 }) { x }; f()", 5},
+		{"let f = fn(a) { a }; f(a: 4)", 4},
+		{"let f = fn(a, b = if (false) { 1 }) { b }; f(1)", NULL},
+		{"let f = fn(a = 5) { a }; f(if (false) { 1 })", NULL},
+		{"let f = fn(b, a = 5) { a }; f(1, a: if (false) { 1 })", NULL},
+		{"let f = fn(x) { x }; f(puts())", NULL},
+		{"let k = 3; let f = fn(a = fn() { k }) { a() }; f()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [first, second, ...tail] = [1, 2, 3, 4]; first + second + len(tail) * 100", 203},
+		{"let [x, ...rest] = [1]; len(rest)", 0},
+		{`let {name, age} = {"name": "ann", "age": 30}; "${name}:${age}"`, "ann:30"},
+		{`let {name: n, tags: [t, ...more]} = {"name": "x", "tags": ["a", "b"]}; n + t + more[0]`, "xab"},
+		{`let [{id}, [z]] = [{"id": 7}, [8]]; id + z`, 15},
+		{"let a = 1; let b = 2; let [a, b] = [b, a]; a * 10 + b", 21},
+		{"let f = fn([a, b], {c}) { a + b + c }; f([1, 2], {\"c\": 3})", 6},
+		{"let f = fn(x, [a, b] = [x, x * 2]) { a + b }; f(5)", 15},
+		{"let f = fn() { let [a, b] = [1, 2]; fn() { a + b } }; f()()", 3},
+		{"let pairs = [[1, 2], [3, 4]]; let sum = 0; for (p in pairs) { let [a, b] = p; sum = sum + a * b }; sum", 14},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = 5", "1:5: TypeError: cannot destructure INTEGER as an array"},
+		{"let [a, b] = [1, 2, 3]", "1:5: ValueError: cannot destructure array of length 3 into 2 elements"},
+		{"let [a, [b, c]] = [1, [2]]", "1:9: ValueError: cannot destructure array of length 1 into 2 elements"},
+		{"let [a, b, ...c] = [1]", "1:5: ValueError: cannot destructure array of length 1 into at least 2 elements"},
+		{"let [a] = []", "1:5: ValueError: cannot destructure array of length 0 into 1 element"},
+		{"let [a, ...b] = []", "1:5: ValueError: cannot destructure array of length 0 into at least 1 element"},
+		{`let {a} = [1]`, "1:5: TypeError: cannot destructure ARRAY as a hash"},
+		{`let {name, age} = {"name": "ann"}`, `1:5: ValueError: cannot destructure hash without key "age"`},
+		{"let f = fn([a]) { a }; f(1)", "1:12: TypeError: cannot destructure INTEGER as an array"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (2) { 1 => "one", _ => "other" }`, "other"},
+		{"match (-1) { 1 => 0, -1 => 10 }", 10},
+		{"match (1.5) { 1 => 0, 1.5 => 1 }", 1},
+		{`match ("1") { 1 => "int", "1" => "string" }`, "string"},
+		{"match (true) { false => 1, true => 2 }", 2},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b }", 3},
+		{"match ([1, 2, 3]) { [2, ...rest] => 0, [1, ...rest] => len(rest) }", 2},
+		{"match ([1]) { [_, _] => 1, [_] => 2 }", 2},
+		{`match ({"type": "x", "data": 5}) { {"type": "y", data} => 0, {"type": "x", data} => data }`, 5},
+		{`match ({"a": 1}) { {b} => b, {a} => a }`, 1},
+		{`match ([[1, 2], {"k": 3}]) { [[a, b], {k}] => a + b + k }`, 6},
+		{"match ([1, 2]) { [x, 3] => 0, [x, y] => x * 10 + y }", 12},
+		{"match (5) { n if n > 10 => 1, n if n > 3 => 2, _ => 3 }", 2},
+		{`match ({"a": [1, 2]}) { {a: [x, y]} if x > y => "desc", {a: [x, y]} => "asc" }`, "asc"},
+		{"match (3) { n => { let d = n * 2; d + 1 } }", 7},
+		{"match (1) { _ => {} }", NULL},
+		{"let x = match (2) { 2 => 20 }; x", 20},
+		{"let f = fn(v) { match (v) { [] => 0, [x, ...xs] => x + f(xs) } }; f([1, 2, 3])", 6},
+		{"let n = 0; for (x in [1, 2, 3, 4]) { match (x) { 3 => { break; } _ => { n = n + x } } }; n", 3},
+		{"let f = fn(x) { match (x) { 1 => { return 10; } _ => 0 }; 5 }; f(1) + f(2)", 15},
+		{"let f = fn(x) { match (x) { [a] if match (a) { 1 => true, _ => false } => a, _ => 0 } }; f([1]) + f([2])", 1},
+		{"let x = 1; match ([2]) { [x] if false => 0, _ => x }", 1},
+		{"let x = 1; match (2) { x => x }; x", 1},
+		{"let f = fn() { let x = 1; match ([2]) { [x] if false => 0, _ => x } }; f()", 1},
+		{"let f = fn() { let x = 1; match (2) { x => { let y = x; y } }; x }; f()", 1},
+		{"let x = 1; match (2) { n => { x = n } }; x", 2},
+		{"let g = match (3) { n => fn() { n } }; g()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatchErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"match (5) { 1 => 1 }", "1:1: MatchError: no match for 5"},
+		{"let f = fn(x) { match (x) { [a] => a } }; f([1, 2])", "1:17: MatchError: no match for [1, 2]"},
+		{`match ([1, "a"]) { [a, b] if b > 0 => 1 }`, "1:32: TypeError: type mismatch: STRING > INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `1:12: ArityError: wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a) { a; }();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `1:13: ArityError: missing argument for parameter a`,
 		},
 		{
 			input:    `fn(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `1:20: ArityError: missing argument for parameter b`,
+		},
+		{
+			input:    `fn(a, b, ...rest) { a + b; }(1);`,
+			expected: `1:29: ArityError: missing argument for parameter b`,
+		},
+		{
+			input:    `fn(a, b, c = 1) { a; }(c: 2);`,
+			expected: `1:23: ArityError: missing arguments for parameters a, b`,
+		},
+		{
+			input:    `fn(a) { a; }(b: 1);`,
+			expected: `1:13: ArityError: unknown keyword argument: b`,
+		},
+		{
+			input:    `fn(a) { a; }(1, a: 2);`,
+			expected: `1:13: ArityError: multiple values for parameter a`,
+		},
+		{
+			input:    `len("x", y: 1);`,
+			expected: `1:4: ArityError: unknown keyword argument: y`,
 		},
 	}
 
-	for _, tt := range tests {
-		program := parse(tt.input)
+	runVmErrorTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, NULL},
+		{`int(3.99)`, 3},
+		{`int(-3.99)`, -3},
+		{`int(7)`, 7},
+		{`int(" 42 ")`, 42},
+		{`int(1e19)`, bigInt("10000000000000000000")},
+		{`int("123456789012345678901234567890")`, bigInt("123456789012345678901234567890")},
+		{`float(1 << 64)`, 18446744073709551616.0},
+		{`float(3)`, 3.0},
+		{`float(2.5)`, 2.5},
+		{`float("1e-3")`, 0.001},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(1)`, "1:4: TypeError: argument to len not supported: INTEGER"},
+		{`len("one", "two")`, "1:4: ArityError: wrong number of arguments: want=1, got=2"},
+		{`int("4x")`, `1:4: ValueError: could not parse "4x" as integer`},
+		{`int(float("inf"))`, "1:4: ValueError: float +Inf out of integer range"},
+		{`int(true)`, "1:4: TypeError: argument to int not supported: BOOLEAN"},
+		{`float("abc")`, `1:6: ValueError: could not parse "abc" as float`},
+		{`let f = fn() { len(1) }; f()`, "1:19: TypeError: argument to len not supported: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+        let newClosure = fn(a) {
+            fn() { a; };
+        };
+        let closure = newClosure(99);
+        closure();
+        `,
+			expected: 99,
+		},
+		{
+			input: `
+        let newAdder = fn(a, b) {
+            fn(c) { a + b + c };
+        };
+        let adder = newAdder(1, 2);
+        adder(8);
+        `,
+			expected: 11,
+		},
+		{
+			input: `
+        let newAdder = fn(a, b) {
+            let c = a + b;
+            fn(d) { c + d };
+        };
+        let adder = newAdder(1, 2);
+        adder(8);
+        `,
+			expected: 11,
+		},
+		{
+			input: `
+        let newAdderOuter = fn(a, b) {
+            let c = a + b;
+            fn(d) {
+                let e = d + c;
+                fn(f) { e + f; };
+            };
+        };
+        let newAdderInner = newAdderOuter(1, 2)
+        let adder = newAdderInner(3);
+        adder(8);
+        `,
+			expected: 14,
+		},
+		{
+			input: `
+        let a = 1;
+        let newAdderOuter = fn(b) {
+            fn(c) {
+                fn(d) { a + b + c + d };
+            };
+        };
+        let newAdderInner = newAdderOuter(2)
+        let adder = newAdderInner(3);
+        adder(8);
+        `,
+			expected: 14,
+		},
+		{
+			input: `
+        let newClosure = fn(a, b) {
+            let one = fn() { a; };
+            let two = fn() { b; };
+            fn() { one() + two(); };
+        };
+        let closure = newClosure(9, 90);
+        closure();
+        `,
+			expected: 99,
+		},
+		{
+			input: `
+        let curry = fn(x) { fn(y) { x + y } };
+        curry(2)(3) + curry(10)(20);
+        `,
+			expected: 35,
+		},
+		{
+			input: `
+        let pair = fn(a) {
+            let first = fn() { a };
+            let second = fn() { a * 2 };
+            [first, second];
+        };
+        let fns = pair(21);
+        fns[0]() + fns[1]();
+        `,
+			expected: 63,
+		},
+	}
 
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+        let countDown = fn(x) {
+            if (x == 0) {
+                return 0;
+            } else {
+                countDown(x - 1);
+            }
+        };
+        countDown(1);
+        `,
+			expected: 0,
+		},
+		{
+			input: `
+        let countDown = fn(x) {
+            if (x == 0) {
+                return 0;
+            } else {
+                countDown(x - 1);
+            }
+        };
+        let wrapper = fn() {
+            countDown(1);
+        };
+        wrapper();
+        `,
+			expected: 0,
+		},
+		{
+			input: `
+        let wrapper = fn() {
+            let countDown = fn(x) {
+                if (x == 0) {
+                    return 0;
+                } else {
+                    countDown(x - 1);
+                }
+            };
+            countDown(1);
+        };
+        wrapper();
+        `,
+			expected: 0,
+		},
+		{
+			input: `
+        let fibonacci = fn(x) {
+            if (x == 0) {
+                return 0;
+            } else {
+                if (x == 1) {
+                    return 1;
+                } else {
+                    fibonacci(x - 1) + fibonacci(x - 2);
+                }
+            }
+        };
+        fibonacci(15);
+        `,
+			expected: 610,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	inputs := []string{
+		"let f = fn() { f() }; f()",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100000)",
+		"let f = fn(n) { let a = n; let b = a; let c = b; 1 + f(c + 1) }; f(0)",
+		"let f = fn(n) { [n, n, n, f(n + 1)] }; f(0)",
+		"let f = fn(n) { try { 1 + f(n + 1) } catch (e) { throw e; } }; f(0)",
+	}
+
+	for _, input := range inputs {
 		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
+		if err := comp.Compile(parse(input)); err != nil {
 			t.Fatalf("compiler error: %s", err)
 		}
+		err := New(comp.Bytecode()).Run()
+		var errObj *object.Error
+		if !errors.As(err, &errObj) || errObj.Kind != object.StackOverflowError {
+			t.Errorf("%q: want StackOverflowError, but got %v", input, err)
+		}
+	}
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
+	runVmTests(t, []vmTestCase{
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(500)", 500},
+	})
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+        let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+        let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+        isEven(10);
+        `,
+			expected: true,
+		},
+		{
+			input: `
+        let parity = fn(x) {
+            let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+            let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+            isOdd(x);
+        };
+        parity(7);
+        `,
+			expected: true,
+		},
+		{
+			input: `
+        let makeIsOdd = fn() {
+            let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+            let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+            isOdd;
+        };
+        let isOdd = makeIsOdd();
+        isOdd(8);
+        `,
+			expected: false,
+		},
+		{
+			input: `
+        let count = fn(n) { if (n == 0) { 0 } else { 1 + helper(n) } };
+        let helper = fn(n) {
+            let step = fn(m) { count(m - 1) };
+            step(n);
+        };
+        count(5);
+        `,
+			expected: 5,
+		},
+		{
+			input: `
+        let a = 1;
+        let get = fn() { a };
+        let a = 2;
+        get();
+        `,
+			expected: 2,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i;", 10},
+		{"let i = 0; while (false) { let i = i + 1; }; i;", 0},
+		{"let i = 0; while (true) { if (i == 5) { break; } let i = i + 1; }; i;", 5},
+		{
+			input: `
+        let i = 0;
+        let sum = 0;
+        while (i < 10) {
+            let i = i + 1;
+            if (i > 3) { continue; }
+            let sum = sum + i;
+        }
+        sum;
+        `,
+			expected: 6,
+		},
+		{
+			input: `
+        let i = 0;
+        let count = 0;
+        while (i < 3) {
+            let j = 0;
+            while (true) {
+                if (j == 4) { break; }
+                let j = j + 1;
+                let count = count + 1;
+            }
+            let i = i + 1;
+        }
+        count;
+        `,
+			expected: 12,
+		},
+		{
+			input: `
+        let find = fn(n) {
+            let i = 0;
+            while (true) {
+                if (i * i > n) { return i; }
+                let i = i + 1;
+            }
+        };
+        find(50);
+        `,
+			expected: 8,
+		},
+		{
+			input: `
+        let sum = fn(n) {
+            let i = 0;
+            let total = 0;
+            while (i < n) {
+                let i = i + 1;
+                let total = total + i;
+            }
+            total;
+        };
+        sum(100000);
+        `,
+			expected: 5000050000,
+		},
+		{"let f = fn() { while (false) { } }; f();", NULL},
+		{"if (true) { let a = 1; }", NULL},
+		{"if (true) { while (false) { } } else { 1 }", NULL},
+		{"let i = 0; while (i < 3) { i = i + 1 }", NULL},
+		{"while (true) { break; }", NULL},
+		{"let c = true; let n = 0; while (n < 3) { n = n + 1; 1 + if (c) { continue } }; n", 3},
+		{"let n = 0; while (true) { n = n + 1; -if (n == 3) { break } else { 1 } }; n", 3},
+		{"let i = 0; while (i < 5000) { i = i + 1; [1, 2, if (true) { continue } else { 0 }] }; i", 5000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum;", 6},
+		{"let sum = 0; for (i, x in [1, 2, 3]) { let sum = sum + i * x; }; sum;", 8},
+		{"let sum = 0; for (x in []) { let sum = sum + 1; }; sum;", 0},
+		{`let n = 0; for (c in "héllo") { let n = n + 1; }; n;`, 5},
+		{`let last = 0; for (i, c in "abc") { let last = i; }; last;`, 2},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2, "c": 3}) { let sum = sum + v; }; sum;`, 6},
+		{`let sum = 0; for (v in {"a": 1, "b": 2}) { let sum = sum + v; }; sum;`, 3},
+		{
+			`
+let sum = 0;
+for (x in [1, 2, 3, 4, 5, 6]) {
+  if (x == 2) { continue; }
+  if (x == 5) { break; }
+  let sum = sum + x;
+}
+sum;`,
+			8,
+		},
+		{
+			`
+let count = fn() {
+  let count = 0;
+  for (x in [1, 2, 3]) {
+    for (y in [1, 2]) {
+      if (y == 2) { break; }
+      let count = count + x;
+    }
+  }
+  count;
+};
+count();`,
+			6,
+		},
+		{
+			`
+let first = fn(arr) {
+  for (x in arr) {
+    if (x > 2) { return x; }
+  }
+  0;
+};
+first([1, 5, 3]) + first([1]);`,
+			5,
+		},
+		{
+			`
+let sum = fn(arr) {
+  let total = 0;
+  for (x in arr) {
+    let add = fn() { total + x };
+    let total = add();
+  }
+  total;
+};
+sum([1, 2, 3, 4]);`,
+			10,
+		},
+		{"for (x in [1, 2]) { x }; 7;", 7},
+		{"fn() { for (x in [1]) {} }()", NULL},
+		{"fn() { for (x in [1]) { break; } }()", NULL},
+		{"if (true) { for (x in [1]) { x } }", NULL},
+		{`let s = ""; for (k, v in {"c": 1, "d": 2, "a": 3, "b": 4}) { s = s + k }; s`, "abcd"},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + match (x) { 2 => { continue }, _ => x } }; s", 4},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { break } else { x } }; s", 1},
+		{"let f = fn() { let s = 0; for (x in [1, 2, 3]) { for (y in [1, 2]) { s = s + [y, if (y == 2) { continue } else { x }][1] } }; s }; f()", 6},
+		{`let n = 0; for (x in [1, 2, 3]) { try { [1, if (x == 2) { throw "e"; } else { 0 }] } catch { 0 }; n = n + [x, if (x == 3) { continue } else { x }][1] }; n`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForStatementErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"for (x in 5) { x }", "1:1: TypeError: not iterable: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x;", 2},
+		{"let x = 1; x = x + 1;", 2},
+		{"let x = 1; let y = 2; x = y = 3; x + y;", 6},
+		{"let x = 1; let f = fn() { x = 5; }; f(); x;", 5},
+		{"let f = fn() { let x = 1; x = x * 10; x }; f();", 10},
+		{
+			`
+let counter = fn() {
+  let n = 0;
+  fn() { n = n + 1; n }
+};
+let c = counter();
+c(); c();
+c();`,
+			3,
+		},
+		{
+			`
+let f = fn() {
+  let n = 1;
+  let set = fn(v) { n = v };
+  set(4);
+  n;
+};
+f();`,
+			4,
+		},
+		{
+			`
+let f = fn(a) {
+  let g = fn() { fn() { a = a * 2 } };
+  g()();
+  a;
+};
+f(21);`,
+			42,
+		},
+		{"let i = 0; while (i < 5) { i = i + 1; }; i;", 5},
+		{"let a = [1, 2, 3]; a[1] = 5; a[0] + a[1] + a[2];", 9},
+		{"let a = [1, 2]; let b = a; b[0] = 7; a[0];", 7},
+		{`let h = {"a": 1}; h["a"] = 2; h["b"] = 3; h["a"] + h["b"];`, 5},
+		{`let h = {"a": [1, 2]}; h["a"][1] = 8; h["a"][1];`, 8},
+		{"let a = [0]; a[0] = 4;", 4},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1]; a[1] = 2;", "1:19: IndexError: index out of range: 1"},
+		{"let h = {}; h[[]] = 1;", "1:19: TypeError: unusable as hash key: ARRAY"},
+		{"let x = 1; x[0] = 1;", "1:17: TypeError: index operator not supported: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"if (false) { 1 } || 3", 3},
+		{"if (false) { 1 } && 3", NULL},
+		{"1 < 2 && 3 > 2 || false", true},
+		{"false && false || true", true},
+		{"let a = []; len(a) > 0 && a[0] == 1", false},
+		{"let a = [1]; len(a) > 0 && a[0] == 1", true},
+		{"let x = 0; false && (x = 1); x", 0},
+		{"let x = 0; true || (x = 1); x", 0},
+		{"let x = 0; true && (x = 1); x", 1},
+		{"let f = fn(a, b) { a || b }; f(false, 7) + f(2, 9)", 9},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUnsupportedComparisons(t *testing.T) {
+	tests := []vmTestCase{
+		{`1 > "a"`, "1:3: TypeError: type mismatch: INTEGER > STRING"},
+		{"true >= false", "1:6: TypeError: unknown operator: BOOLEAN >= BOOLEAN"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"5.0 - 7", -2.0},
+		{"2 * 1.5", 3.0},
+		{"7 / 2.0", 3.5},
+		{"1 / 4 * 1.0", 0.0},
+		{"float(1) / 4", 0.25},
+		{"let total = 8; let done = 3; done * 100.0 / total", 37.5},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"2.0 >= 2", true},
+		{"1.9 <= 1", false},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmeticErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5 / 0", "1:5: ZeroDivisionError: division by zero"},
+		{"1.5 % 2", "1:5: TypeError: unknown operator: FLOAT % INTEGER"},
+		{`1.5 + "a"`, "1:5: TypeError: type mismatch: FLOAT + STRING"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"let max = 9223372036854775807; max + 1 - 1", 9223372036854775807},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4611686018427387904 * 4", bigInt("18446744073709551616")},
+		{"1 << 64", bigInt("18446744073709551616")},
+		{"(1 << 64) / (1 << 62)", 4},
+		{"(1 << 64) % 7", 2},
+		{"(1 << 64) - (1 << 64)", 0},
+		{"(1 << 64) >> 64", 1},
+		{"(1 << 64) | 1", bigInt("18446744073709551617")},
+		{"((1 << 64) | 5) & 7", 5},
+		{"~(1 << 64)", bigInt("-18446744073709551617")},
+		{"let m = -9223372036854775807 - 1; m / -1", bigInt("9223372036854775808")},
+		{"let m = -9223372036854775807 - 1; -m", bigInt("9223372036854775808")},
+		{"(1 << 64) == (1 << 64)", true},
+		{"(1 << 64) != (1 << 65)", true},
+		{"(1 << 64) == 1", false},
+		{"(1 << 64) > 9223372036854775807", true},
+		{"-(1 << 64) < 0", true},
+		{"(1 << 64) >= (1 << 64)", true},
+		{"(1 << 64) + 0.5", 18446744073709551616.5},
+		{"9223372036854775808", bigInt("9223372036854775808")},
+		{"18446744073709551616 - 1", bigInt("18446744073709551615")},
+		{"-9223372036854775808", -9223372036854775808},
+		{"{(1 << 64): 5}[1 << 64]", 5},
+		{
+			`
+let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+fact(25);`,
+			bigInt("15511210043330985984000000"),
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let f = fn(x) {
+  x + true
+};
+let y = 1;
+f(y);`,
+			expected: "2:5: TypeError: type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			input: `let xs = [1, 2];
+let g = fn() { xs(1) };
+g()`,
+			expected: "2:18: TypeError: not a function: ARRAY",
+		},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn() { inner(1) };
+let run = fn(f) { f() };
+run(outer);`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error should be *RuntimeError, but got %T (%v)", err, err)
+	}
+
+	want := []string{
+		"inner 2:5",
+		"outer 4:25",
+		"run 5:20",
+		"<main> 6:4",
+	}
+	if len(runtimeErr.Trace) != len(want) {
+		t.Fatalf("trace want %d entries, but got %d:\n%s", len(want), len(runtimeErr.Trace), runtimeErr.StackTrace())
+	}
+	for i, entry := range runtimeErr.Trace {
+		if got := entry.Function + " " + entry.Pos.String(); got != want[i] {
+			t.Errorf("trace[%d] want %q, but got %q", i, want[i], got)
+		}
+	}
+
+	anonymous := `fn() { 1 + "a" }()`
+	comp = compiler.New()
+	err = comp.Compile(parse(anonymous))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	wantTrace := "    at <anonymous> (1:10)\n    at <main> (1:17)\n"
+	if got := err.(*RuntimeError).StackTrace(); got != wantTrace {
+		t.Fatalf("stack trace want %q, but got %q", wantTrace, got)
+	}
+}
+
+func TestErrorsMatchEvaluator(t *testing.T) {
+	inputs := []string{
+		"5 + true",
+		"-true",
+		"~true",
+		"true + false",
+		`"a" - "b"`,
+		`1 < "a"`,
+		"true >= false",
+		"1 / 0",
+		"1.5 / 0",
+		"1.5 % 2",
+		"1 << -1",
+		`[1]["a"]`,
+		"1[0]",
+		"{[1]: 1}",
+		"for (x in 5) { x }",
+		"fn(a) { a }()",
+		"let x = 5;\nx(1)",
+		"len(1)",
+		`int("x")`,
+		"let a = [1]; a[1] = 2;",
+		"[1, 2, 3][99]",
+		"[1][-1]",
+		`{"a": 1}[fn() { 1 }]`,
+		"fn() { 1 } + 1",
+		"let f = fn() { 1 }; -f",
+		"let f = fn() { g() };\nf();\nlet g = fn() { 1 };",
+		"let f = fn() { f() }; f()",
+		"puts() + 1",
+		"let f = fn() { a(); let a = fn() { 1 } }; f()",
+		"let f = fn() { let g = fn() { h() }; g(); let h = fn() { 1 } }; f()",
+		"fn() { if (false) { let a = 1 }; a }()",
+		`9223372036854775808 + "a"`,
+		"[1][1 << 64]",
+		"let a = [1]; a[1 << 64] = 2",
+		`[1]["a"] = 2`,
+	}
+
+	for _, input := range inputs {
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		want, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%q: evaluator should fail, but got %s", input, evaluated.Inspect())
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		var got *object.Error
+		if !errors.As(err, &got) {
+			t.Fatalf("%q: VM error should wrap *object.Error, but got %v", input, err)
+		}
+		if got.Kind != want.Kind || got.Message != want.Message || got.Pos != want.Pos {
+			t.Errorf("%q: VM error %q differs from evaluator error %q", input, got.Inspect(), want.Inspect())
+		}
+	}
+}
+
+func TestAssignErrorsMatchEvaluator(t *testing.T) {
+	inputs := []string{
+		"x = 1",
+		"let x = 1;\n  y = x",
+		"fn() { y = 1 }()",
+	}
+
+	for _, input := range inputs {
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		want, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%q: evaluator should fail, but got %s", input, evaluated.Inspect())
+		}
+
+		err := compiler.New().Compile(parse(input))
 		if err == nil {
-			t.Fatalf("expected VM error but resulted in none.")
+			t.Fatalf("%q: expected compiler error", input)
 		}
+		if !strings.HasPrefix(err.Error(), want.Pos.String()+": ") {
+			t.Errorf("%q: compiler error %q is not at the evaluator error position %s", input, err, want.Pos)
+		}
+	}
+}
 
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+func TestResultsMatchEvaluator(t *testing.T) {
+	inputs := []string{
+		"let i = 0; while (i < 3) { i = i + 1 }",
+		"fn() { while (true) { break; } }()",
+		"fn() { for (x in [1]) {} }()",
+		"for (x in [1, 2]) { if (x == 2) { break; } }",
+		"99999999999999999999999999 * 10",
+		"match (9223372036854775808) { 9223372036854775808 => 1, _ => 2 }",
+		"if (puts()) { 1 } else { 2 }",
+		`let s = ""; for (k, v in {"c": 1, 2: 2, "a": 3, true: 4, 1: 5}) { s = s + "${k}" }; s`,
+		"let f = fn() { 1 + if (true) { return 5; } }; f()",
+		"let f = fn(x = if (true) { return 7; }) { x + 1 }; f()",
+		"[puts()]",
+		`"${puts()}"`,
+	}
+
+	for _, input := range inputs {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%q: vm error: %s", input, err)
+		}
+		if got := vm.LastPoppedStackElem(); got.Inspect() != want.Inspect() {
+			t.Errorf("%q: VM result %s differs from evaluator result %s", input, got.Inspect(), want.Inspect())
 		}
 	}
 }
 
-func TestBuiltinFunctions(t *testing.T) {
+func TestTryExpressions(t *testing.T) {
 	tests := []vmTestCase{
-		{`len("")`, 0},
-		{`len("four")`, 4},
-		{`len("hello world")`, 11},
+		{"try { 1 } catch (e) { 2 }", 1},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { try { 1 / 0 } catch (e) { throw e; } } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+		{`try { try { 1 / 0 } catch (e) { e["kind"] = "X"; throw e; } } catch (e) { e["kind"] + ":" + e["message"] }`, "X:division by zero"},
+		{`try { try { 1 / 0 } catch (e) { e["message"] = "m"; throw e; } } catch (e) { e["kind"] + ":" + e["message"] }`, "ZeroDivisionError:m"},
+		{`try { try { 1 / 0 } catch (e) { e["kind"] = 1; throw e; } } catch (e) { e["message"] }`, "division by zero"},
+		{`try { throw "bad"; } catch (e) { e }`, "bad"},
+		{"try { throw 5; } catch { 7 }", 7},
+		{"try { } catch { 7 }", NULL},
+		{"let x = 0; try { x = 1 } finally { x = x + 10 }; x", 11},
+		{"let n = 0; let f = fn() { try { return 1; } finally { n = n + 1 } }; f() + f() + n", 4},
+		{`let f = fn(x) { if (x == 0) { throw "zero"; } f(x - 1) }; try { f(3) } catch (e) { e }`, "zero"},
 		{
-			`len(1)`,
-			&object.Error{
-				Message: "type mismatch: INTEGER",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "arguments len 2 mismatch, want 1",
-			},
+			`
+let total = 0;
+for (s in ["1", "x", "3"]) {
+  total = total + try { int(s) } catch (e) { 0 };
+}
+total;`,
+			4,
 		},
-		{`len([1, 2, 3])`, 3},
-		{`len([])`, 0},
-		{`puts("hello", "world!")`, NULL},
+		{"try { try { throw 1; } finally { 2 } } catch (e) { e + 10 }", 11},
+		{`try { try { 1 / 0 } catch (e) { throw e["message"]; } } catch (e) { e }`, "division by zero"},
+		{"let n = 0; while (true) { try { break; } finally { n = n + 1 } }; n", 1},
+		{"let n = 0; let i = 0; while (i < 3) { i = i + 1; try { continue; } finally { n = n + 1 } }; n", 3},
+		{"let n = 0; try { try { throw 1; } catch (e) { throw 2; } finally { n = 5 } } catch (e) { e + n }", 7},
+		{"let g = fn() { let a = 1; let h = fn() { a }; throw h; }; try { g() } catch (h) { h() }", 1},
+		{"let f = fn() { try { throw 1; } catch (e) { return e + 1; } }; f()", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`throw "bad record";`, "1:1: Exception: bad record"},
+		{"try { 1 } finally { 1 / 0 }", "1:23: ZeroDivisionError: division by zero"},
+		{"try { throw 1; } finally { 2 }", "1:7: Exception: 1"},
+		{`let f = fn() { throw "deep"; }; try { f() } finally { 0 }`, "1:16: Exception: deep"},
+		{"try { 1 + true } catch (e) { throw e; }", "1:9: TypeError: type mismatch: INTEGER + BOOLEAN"},
+		{`try { 1 + true } catch (e) { e["kind"] = "Oops"; throw e; }`, "1:9: Oops: type mismatch: INTEGER + BOOLEAN"},
+		{`throw {"b": 1, "a": [2], 10: 3, 9: 4};`, `1:1: Exception: {9:4, 10:3, "a":[2], "b":1}`},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	tests := []vmTestCase{
+		{`let s = import("../testdata/lib/strings.mk"); s["shout"](s["greeting"])`, "hello!"},
+		{`import "../testdata/lib/util.mk"; util["twice"]("hi")`, "hi!!"},
+		{`import "../testdata/lib/strings.mk"; let again = import("../testdata/lib/strings.mk"); strings["counter"](); again["counter"]()`, 2},
+		{`import "../testdata/lib/strings.mk"; len(strings)`, 3},
+		{`let f = fn() { import("../testdata/lib/strings.mk") }; f()["greeting"]`, "hello"},
+		{`import "../testdata/lib/strings.mk"; let n = 0; for (k, v in strings) { n = n + 1 }; n`, 3},
+		{`let {shout, greeting} = import("../testdata/lib/strings.mk"); shout(greeting)`, "hello!"},
+		{`let {x, y, norm} = import("../testdata/lib/point.mk"); x + y + norm()`, 32},
 	}
 
 	runVmTests(t, tests)
 }
+
+func TestImportErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`import "../testdata/lib/strings.mk"; strings["count"]`, "1:45: NameError: module ../testdata/lib/strings.mk has no export count"},
+		{`import "../testdata/fail.mk"`, "../testdata/fail.mk:1:18: ZeroDivisionError: division by zero"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestMacros(t *testing.T) {
+	input := `
+let unless = macro(cond, cons, alt) {
+  quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) })
+};
+let x = 0;
+unless(x > 1, x = 10, x = 20);
+unless(x > 1, x + 1, x + 2);
+`
+	program := parse(input)
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := evaluator.ExpandMacros(program, macroEnv)
+	if err != nil {
+		t.Fatalf("macro expansion failed: %s", err)
+	}
+
+	comp := compiler.New()
+	err = comp.Compile(expanded)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 12, vm.LastPoppedStackElem())
+}
+
+func TestModulesAcrossPrograms(t *testing.T) {
+	// programs compiled and run one after another, as the REPL does
+	var constants []object.Object
+	globals := make([]object.Object, GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	inputs := []string{
+		`import "../testdata/lib/strings.mk"; strings["counter"]()`,
+		`import "../testdata/lib/strings.mk"; strings["counter"]()`,
+		`import "../testdata/lib/macros.mk"; nope`,
+		`len(1); import "../testdata/lib/macros.mk"`,
+		`import "../testdata/lib/macros.mk"; macros["double"](strings["counter"]())`,
+	}
+	expected := []interface{}{
+		1,
+		2,
+		"1:37: undefined variable nope",
+		"1:4: TypeError: argument to len not supported: INTEGER",
+		6,
+	}
+
+	for i, input := range inputs {
+		comp := compiler.NewWithState(symbolTable, constants)
+		comp.SetModuleParser(evaluator.ParseModule)
+		err := comp.Compile(parse(input))
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		vm := NewWithGlobalsStore(bytecode, globals)
+		if err == nil {
+			err = vm.Run()
+		}
+		if msg, ok := expected[i].(string); ok {
+			if err == nil || err.Error() != msg {
+				t.Fatalf("%q: want error %q, but got %v", input, msg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: error: %s", input, err)
+		}
+		testExpectedObject(t, expected[i], vm.LastPoppedStackElem())
+	}
+}
+
+func TestModuleMacros(t *testing.T) {
+	comp := compiler.New()
+	comp.SetModuleParser(evaluator.ParseModule)
+	err := comp.Compile(parse(`import "../testdata/lib/macros.mk"; macros["double"](21)`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 42, vm.LastPoppedStackElem())
+}